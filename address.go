@@ -0,0 +1,109 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// checkHostPort makes sure a MUSH address can be dialed, so a typo shows up
+// at startup rather than as an endless series of reconnects.
+func checkHostPort(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	switch {
+	case err != nil:
+	case host == "":
+		err = errors.New("missing host")
+	case port == "":
+		err = errors.New("missing port")
+	default:
+		_, err = net.LookupPort("tcp", port)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not host:port, IPv6 addresses go in brackets like [2001:db8::1]:4201: %w", address, err)
+	}
+	return nil
+}
+
+// familyDialer dials the addresses of a host name in the preferred family
+// first, either ip4 or ip6, and falls back to the others. timeout covers
+// resolving and trying all of them.
+type familyDialer struct {
+	prefer  string
+	timeout time.Duration
+}
+
+func (d familyDialer) Dial(network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return d.preferred(addrs[i].IP) && !d.preferred(addrs[j].IP)
+	})
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func (d familyDialer) preferred(ip net.IP) bool {
+	return (ip.To4() != nil) == (d.prefer == "ip4")
+}
+
+// directDialer returns the dialer for connections to the MUSH, or to the
+// proxy, with the address family preference and the timeout given on the
+// command line. A host which drops the SYNs would otherwise keep the
+// attempt going for minutes.
+func directDialer(config *ServerConfig) proxy.Dialer {
+	switch {
+	case config.PreferIPv4:
+		return familyDialer{prefer: "ip4", timeout: config.DialTimeout}
+	case config.PreferIPv6:
+		return familyDialer{prefer: "ip6", timeout: config.DialTimeout}
+	}
+	return &net.Dialer{Timeout: config.DialTimeout}
+}
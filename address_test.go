@@ -0,0 +1,149 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHostPort(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		valid   bool
+	}{
+		{"mush.example.com:4201", true},
+		{"localhost:4201", true},
+		{"192.0.2.1:4201", true},
+		{"[2001:db8::1]:4201", true},
+		{"[::1]:23", true},
+		{"[fe80::1%eth0]:4201", true},
+		{"mush.example.com:telnet", true},
+		{"2001:db8::1:4201", false},
+		{"[2001:db8::1]", false},
+		{"2001:db8::1", false},
+		{"mush.example.com", false},
+		{"mush.example.com:", false},
+		{":4201", false},
+		{"mush.example.com:notaport", false},
+		{"[2001:db8::1]:4201:1", false},
+	} {
+		err := checkHostPort(tc.address)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: %v, want valid %v", tc.address, err, tc.valid)
+		}
+		if err != nil && !strings.Contains(err.Error(), "[2001:db8::1]:4201") {
+			t.Errorf("%s: the error doesn't tell how to write IPv6: %v", tc.address, err)
+		}
+	}
+}
+
+func TestInvalidHostAtStartup(t *testing.T) {
+	if _, err := newGames(testConfig(t, "--host", "2001:db8::1:4201")); err == nil || !strings.Contains(err.Error(), "--host") {
+		t.Errorf("--host 2001:db8::1:4201: %v", err)
+	}
+	if _, err := newGames(testConfig(t, "--game", "other:[2001:db8::1]")); err == nil || !strings.Contains(err.Error(), "--game other") {
+		t.Errorf("--game other:[2001:db8::1]: %v", err)
+	}
+	if _, err := newGames(testConfig(t, "--host", "[2001:db8::1]:4201")); err != nil {
+		t.Errorf("--host [2001:db8::1]:4201: %v", err)
+	}
+}
+
+func TestFamilyPreference(t *testing.T) {
+	v4, v6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	mapped := net.ParseIP("::ffff:192.0.2.1")
+	for _, tc := range []struct {
+		prefer string
+		ip     net.IP
+		want   bool
+	}{
+		{"ip4", v4, true},
+		{"ip4", v6, false},
+		{"ip4", mapped, true},
+		{"ip6", v4, false},
+		{"ip6", v6, true},
+	} {
+		if got := (familyDialer{prefer: tc.prefer}).preferred(tc.ip); got != tc.want {
+			t.Errorf("%s prefers %s: %v, want %v", tc.prefer, tc.ip, got, tc.want)
+		}
+	}
+}
+
+// TestDialLiterals dials an IPv4 and an IPv6 literal through every dialer
+// directDialer can return.
+func TestDialLiterals(t *testing.T) {
+	for _, network := range []string{"tcp4", "tcp6"} {
+		host := "127.0.0.1"
+		if network == "tcp6" {
+			host = "::1"
+		}
+		listener, err := net.Listen(network, net.JoinHostPort(host, "0"))
+		if err != nil {
+			t.Logf("no %s on loopback: %v", network, err)
+			continue
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		address := listener.Addr().String()
+		if err := checkHostPort(address); err != nil {
+			t.Errorf("%s: %v", address, err)
+		}
+		for _, args := range [][]string{nil, {"--prefer-ipv4"}, {"--prefer-ipv6"}} {
+			config := testConfig(t, append(args, "--telnet-dial-timeout", "1s", "--host", address)...)
+			conn, err := directDialer(&config).Dial("tcp", address)
+			if err != nil {
+				t.Errorf("%s with %v: %v", address, args, err)
+				continue
+			}
+			conn.Close()
+		}
+	}
+}
+
+// TestDialFallsBack dials localhost, which resolves to both families on
+// most hosts. Whichever is preferred, the one that listens gets the
+// connection.
+func TestDialFallsBack(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	conn, err := familyDialer{prefer: "ip6", timeout: time.Second}.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
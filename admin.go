@@ -0,0 +1,118 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+var dbrefPattern = regexp.MustCompile(`^#[0-9]+$`)
+
+type RefreshResponse struct {
+	State string `json:"state"`
+	// Coalesced is set when a refresh was already pending.
+	Coalesced bool `json:"coalesced"`
+}
+
+// serveRefresh asks loopWorker to poll WHO right away instead of waiting
+// for the next tick. It returns without waiting for the MUSH to answer.
+func (s *ServerState) serveRefresh(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	state := s.currentState
+	s.mu.RUnlock()
+	if state != STATE_IDLE {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("Cannot refresh while %s", state))
+		return
+	}
+
+	response := RefreshResponse{State: state}
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+		response.Coalesced = true
+	}
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jsonBody)
+}
+
+type CacheClearResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// serveCacheClear drops cached location names, either all of them or the
+// one given as dbref, so renamed rooms are picked up by the next WHO.
+func (s *ServerState) serveCacheClear(w http.ResponseWriter, r *http.Request) {
+	dbref := r.FormValue("dbref")
+	if dbref != "" && !dbrefPattern.MatchString(dbref) {
+		writeJSONError(w, http.StatusBadRequest, "dbref must look like #123")
+		return
+	}
+
+	s.mu.Lock()
+	var response CacheClearResponse
+	if dbref == "" {
+		response.Cleared = s.locations.Clear()
+	} else if s.locations.Evict(dbref) {
+		response.Cleared = 1
+	}
+	// Players in the cleared locations are now shown by dbref.
+	s.publishState()
+	s.mu.Unlock()
+	s.logRequest(r, slog.LevelInfo, "Cleared %d cached locations", response.Cleared)
+
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
+
+type ReconnectResponse struct {
+	PreviousState string `json:"previousState"`
+}
+
+// serveReconnect drops the telnet session, e.g. when it is wedged after the
+// MUSH rebooted, and lets the next tick dial a fresh one.
+func (s *ServerState) serveReconnect(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	response := ReconnectResponse{PreviousState: s.currentState}
+	s.disconnect()
+	s.mu.Unlock()
+	s.logRequest(r, slog.LevelInfo, "Reconnecting, was %s", response.PreviousState)
+
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jsonBody)
+}
@@ -0,0 +1,60 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "strings"
+
+// processAnnouncement looks for the MUSH disconnecting us or announcing a
+// restart, after which it shows the login screen again without closing the
+// connection. It reports whether the message was either. The caller holds
+// s.mu.
+func (s *ServerState) processAnnouncement(message string) bool {
+	if s.bootMatch != nil && s.bootMatch.MatchString(message) {
+		// Telling this apart from a network error tells the admin whether
+		// to look at the game or the network.
+		s.logger.Println("Disconnected by the MUSH.")
+		s.recordError("disconnected by the MUSH")
+		s.metrics.RecordDisconnect()
+		s.disconnect()
+		s.scheduleReconnect()
+		return true
+	}
+	if s.restartMatch == nil || !isLoggedIn(s.currentState) {
+		return false
+	}
+	match := s.restartMatch.FindStringIndex(message)
+	if match == nil {
+		return false
+	}
+	s.logger.Println("The MUSH is restarting, logging in again.")
+	s.metrics.RecordRestart()
+	s.queue = nil
+	s.setState(STATE_CONNECTING)
+
+	// The new login screen may have come along in the same message.
+	rest := message[match[1]:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[i+1:]
+	} else {
+		rest = ""
+	}
+	if strings.TrimSpace(rest) != "" {
+		s.processMessage(rest)
+	}
+	return true
+}
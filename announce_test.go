@@ -0,0 +1,86 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultRestartPattern(t *testing.T) {
+	pattern := regexp.MustCompile(testConfig(t).RestartMatch)
+	for _, tc := range []struct {
+		message string
+		restart bool
+	}{
+		{"GAME: Restart by Wizard, please wait.\r\n", true},
+		{"GAME: Reboot by Wizard.\r\n", true},
+		{"GAME: Shutdown by Wizard.\r\n", true},
+		{"3 Players logged in.\r\nGAME: Restart by Wizard.\r\n", true},
+		{"Walker says, \"GAME: Restart by Wizard.\"\r\n", false},
+		{"GAME: Dump complete.\r\n", false},
+	} {
+		if got := pattern.MatchString(tc.message); got != tc.restart {
+			t.Errorf("%q: restart %v, want %v", tc.message, got, tc.restart)
+		}
+	}
+}
+
+// TestScriptedRestart plays a restart in which the login screen only comes
+// a while after the announcement, as on a real MUSH.
+func TestScriptedRestart(t *testing.T) {
+	mush := newFakeMUSH(t)
+	ts := startServer(t, mush, serverEnv{tick: 50 * time.Millisecond}, "--restart-pattern", `(?m)^\*\*\* Rebooting`)
+	ts.waitForPlayers()
+
+	mush.Forget()
+	mush.Announce("*** Rebooting, please wait.\r\n")
+	waitFor(t, "the restart", func() bool {
+		return ts.currentState() == STATE_CONNECTING
+	})
+	// A few ticks go by. The connect screen would answer WHO, so it mustn't
+	// be polled.
+	time.Sleep(300 * time.Millisecond)
+	for len(mush.lines) > 0 {
+		if line := <-mush.lines; strings.EqualFold(line, "WHO") || strings.HasPrefix(line, "connect") {
+			t.Errorf("sent %q before the login screen", line)
+		}
+	}
+
+	mush.Announce(readFixture(t, "banner.txt"))
+	mush.Expect("connect bot secret")
+	waitFor(t, "the login after the restart", func() bool {
+		_, logins := mush.Counts()
+		return logins == 2 && ts.currentState() == STATE_IDLE
+	})
+	ts.waitForPlayers()
+
+	// With a pattern of its own, the default announcement is no restart.
+	mush.Announce("GAME: Restart by Wizard.\r\n")
+	time.Sleep(100 * time.Millisecond)
+	var stats Stats
+	ts.get("/api/stats", &stats)
+	if stats.Restarts != 1 {
+		t.Errorf("%d restarts, want 1", stats.Restarts)
+	}
+	if dials, _ := mush.Counts(); dials != 1 {
+		t.Errorf("dialed %d times, want once", dials)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ansiPattern matches the escape sequences MUSHes colour their output with:
+// CSI sequences such as SGR colours, OSC sequences, which end in BEL or ST,
+// and the other two byte escapes.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+var controlStripper = strings.NewReplacer("\a", "", "\b", "")
+
+// stripANSI removes escape sequences, bells and backspaces from text, so
+// that neither the parsers nor the JSON see them.
+func stripANSI(text string) string {
+	if !strings.ContainsAny(text, "\x1b\a\b") {
+		return text
+	}
+	return controlStripper.Replace(ansiPattern.ReplaceAllString(text, ""))
+}
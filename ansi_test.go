@@ -0,0 +1,76 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"Town Square", "Town Square"},
+		{"\x1b[1;32mTown Square\x1b[0m", "Town Square"},
+		{"\x1b[38;5;208mOrange\x1b[m and \x1b[38;2;1;2;3mtrue colour\x1b[39m", "Orange and true colour"},
+		{"\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1b]0;title\x07text", "text"},
+		{"\x1b[2J\x1b[Hcleared", "cleared"},
+		{"\x1bMup", "up"},
+		{"ding\a dong\b", "ding dong"},
+		{"Zoë \x1b[31m❤\x1b[0m", "Zoë ❤"},
+		{"unterminated \x1b[", "unterminated \x1b["},
+	} {
+		if got := stripANSI(tc.text); got != tc.want {
+			t.Errorf("stripANSI(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestColoredWho(t *testing.T) {
+	mush := newFakeMUSH(t)
+	mush.SetWho("who_ansi.txt")
+	mush.rooms["#10"] = "\x1b[1;32mTown \x1b[4mSquare\x1b[0m"
+	ts := startServer(t, mush, serverEnv{})
+
+	status := ts.waitForPlayers()
+	for i, want := range []PlayerV1{
+		{Name: "Rhee", Location: "Town Square"},
+		{Name: "Sam", Location: "The Docks"},
+		{Name: "Walker", Location: "Town Square"},
+	} {
+		if status.Players[i] != want {
+			t.Errorf("player %d is %+v, want %+v", i, status.Players[i], want)
+		}
+	}
+}
+
+func TestKeepANSI(t *testing.T) {
+	mush := newFakeMUSH(t)
+	mush.SetWho("who.txt")
+	mush.rooms["#11"] = "\x1b[34mThe Docks\x1b[0m"
+	ts := startServer(t, mush, serverEnv{}, "--keep-ansi")
+
+	waitFor(t, "the coloured room name", func() bool {
+		var status StatusV1
+		ts.get("/api/v1", &status)
+		return len(status.Players) == 3 && strings.Contains(status.Players[1].Location, "\x1b[34m")
+	})
+}
@@ -0,0 +1,283 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// servePlayer serves single players below prefix in the schema of the given
+// API version.
+func (s *ServerState) servePlayer(version int, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		if name == "" || strings.Contains(name, "/") {
+			writeJSONError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		raw, _ := strconv.ParseBool(r.URL.Query().Get("raw"))
+
+		s.mu.RLock()
+		player := s.findPlayer(name)
+		var jsonBody []byte
+		var err error
+		if player != nil {
+			jsonBody, err = json.Marshal(playerResponse(version, player, raw))
+		}
+		s.mu.RUnlock()
+
+		if player == nil {
+			writeJSONError(w, http.StatusNotFound, "Player not connected")
+			return
+		}
+		if err != nil {
+			writeInternalError(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBody)
+	}
+}
+
+// findPlayer looks up a connected player by name, ignoring case like the
+// MUSH does. The caller must hold s.mu.
+func (s *ServerState) findPlayer(name string) *MushPlayer {
+	for _, player := range s.mushState.Players {
+		if player != nil && strings.EqualFold(player.Name, name) {
+			return player
+		}
+	}
+	return nil
+}
+
+// filterByLocation keeps the players whose resolved location name matches
+// any of the given names, ignoring case.
+func filterByLocation(players []*MushPlayer, locations []string) []*MushPlayer {
+	filtered := make([]*MushPlayer, 0)
+	for _, player := range players {
+		if player == nil {
+			continue
+		}
+		name := player.LocationName()
+		for _, location := range locations {
+			if strings.EqualFold(name, location) {
+				filtered = append(filtered, player)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// filterByNamePrefix keeps the players whose name starts with prefix,
+// ignoring case, like WHO does when given a prefix.
+func filterByNamePrefix(players []*MushPlayer, prefix string) []*MushPlayer {
+	filtered := make([]*MushPlayer, 0)
+	for _, player := range players {
+		if player == nil {
+			continue
+		}
+		if len(player.Name) >= len(prefix) && strings.EqualFold(player.Name[:len(prefix)], prefix) {
+			filtered = append(filtered, player)
+		}
+	}
+	return filtered
+}
+
+// parsePage reads the ?limit= and ?offset= parameters. A limit of -1 means
+// no limit.
+func parsePage(query url.Values) (int, int, error) {
+	limit, offset := -1, 0
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("limit must be a non-negative integer")
+		}
+		limit = parsed
+	}
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = parsed
+	}
+	return limit, offset, nil
+}
+
+// paginate returns the requested page of the already sorted players.
+func paginate(players []*MushPlayer, limit int, offset int) []*MushPlayer {
+	if offset >= len(players) {
+		return players[:0]
+	}
+	players = players[offset:]
+	if limit >= 0 && limit < len(players) {
+		players = players[:limit]
+	}
+	return players
+}
+
+// maxWait caps how long a long polling request is held.
+const maxWait = time.Minute
+
+// parseWait reads the ?wait= and ?rev= parameters for long polling. Without
+// wait the duration is zero.
+func parseWait(query url.Values) (time.Duration, uint64, error) {
+	value := query.Get("wait")
+	if value == "" {
+		return 0, 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait <= 0 {
+		return 0, 0, errors.New("wait must be a positive duration")
+	}
+	rev, err := strconv.ParseUint(query.Get("rev"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("rev must be given as a revision number with wait")
+	}
+	return min(wait, maxWait), rev, nil
+}
+
+// waitForChange blocks until the state revision differs from rev, and
+// reports whether it does. It gives up after wait or once ctx is done.
+func (s *ServerState) waitForChange(ctx context.Context, rev uint64, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		s.mu.RLock()
+		revision, changed := s.revision, s.changed
+		s.mu.RUnlock()
+		if revision != rev {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+type playerOrder func(a, b *MushPlayer) int
+
+func compareNames(a, b *MushPlayer) int {
+	return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+}
+
+// Ties are broken by name, so the order is always deterministic.
+var playerOrders = map[string]playerOrder{
+	"name": compareNames,
+	"location": func(a, b *MushPlayer) int {
+		if c := strings.Compare(strings.ToLower(a.LocationName()), strings.ToLower(b.LocationName())); c != 0 {
+			return c
+		}
+		return compareNames(a, b)
+	},
+	"idle": func(a, b *MushPlayer) int {
+		if a.Idle != b.Idle {
+			if a.Idle < b.Idle {
+				return -1
+			}
+			return 1
+		}
+		return compareNames(a, b)
+	},
+}
+
+// parseSort reads the ?sort= parameter: name, location or idle, prefixed
+// with - for descending order. It defaults to sorting by name.
+func parseSort(value string) (playerOrder, error) {
+	key, descending := strings.CutPrefix(value, "-")
+	if key == "" && !descending {
+		key = "name"
+	}
+	order, ok := playerOrders[key]
+	if !ok {
+		return nil, errors.New("sort must be one of name, location or idle, optionally prefixed with -")
+	}
+	if descending {
+		return func(a, b *MushPlayer) int { return order(b, a) }, nil
+	}
+	return order, nil
+}
+
+// sortPlayers returns a sorted copy of the players.
+func sortPlayers(players []*MushPlayer, order playerOrder) []*MushPlayer {
+	sorted := slices.Clone(players)
+	slices.SortFunc(sorted, order)
+	return sorted
+}
+
+type LocationsResponse struct {
+	Locations map[string]string `json:"locations"`
+	Unknown   []string          `json:"unknown"`
+	Failed    []string          `json:"failed"`
+}
+
+func (s *ServerState) serveLocations(w http.ResponseWriter, r *http.Request) {
+	names, unknown := s.locations.Snapshot()
+	// encoding/json writes map keys sorted, which keeps the output stable.
+	jsonBody, err := json.Marshal(LocationsResponse{
+		Locations: names,
+		Unknown:   unknown,
+		Failed:    s.locations.Failed(),
+	})
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
+
+var csvHeader = []string{"name", "location", "doing"}
+
+func (s *ServerState) serveCSV(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	rows := make([][]string, 0, len(s.mushState.Players))
+	for _, player := range s.mushState.Players {
+		if player != nil {
+			rows = append(rows, []string{player.Name, player.LocationName(), player.Doing})
+		}
+	}
+	s.mu.RUnlock()
+
+	filename := fmt.Sprintf("players-%s.csv", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		s.logRequest(r, slog.LevelError, "Could not write csv: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// assembleQuiet is how long a response which is still incomplete may go
+// without more text before it is parsed as it is.
+const assembleQuiet = 2 * time.Second
+
+// newlines turns the CR LF, LF CR and lone CR line endings some MUSHes send
+// into LF.
+var newlines = strings.NewReplacer("\r\n", "\n", "\n\r", "\n", "\r", "\n")
+
+// normalizeNewlines ends every line of a complete response with LF only. A
+// chunk can't be normalized on its own, since it may end between the CR
+// and LF.
+func normalizeNewlines(text string) string {
+	return newlines.Replace(text)
+}
+
+// isCompleteWho reports whether the WHO output got as far as its trailer.
+func isCompleteWho(text string) bool {
+	text = normalizeNewlines(text)
+	lines := strings.Split(strings.TrimRight(text, "\r\n"), "\n")
+	return strings.HasSuffix(text, "\n") && strings.Contains(lines[len(lines)-1], "logged in")
+}
+
+// isCompleteLocation reports whether the say which answers a location
+// lookup got as far as its closing quote.
+func isCompleteLocation(text string) bool {
+	return strings.HasSuffix(text, "\n") && strings.Count(text, "\"") >= 3
+}
+
+// assemble collects the chunks of a response which arrives in several
+// messages, e.g. a long WHO on a slow link, and returns the text once
+// complete says it's all there. Until then it returns false and waits for
+// more, or for assembleQuiet to pass. complete is only a guess, so once the
+// MUSH was seen to end its responses with GA or EOR, assemble waits for
+// that instead, and with --output-brackets for the suffix line. The caller
+// holds s.mu.
+func (s *ServerState) assemble(message string, complete func(string) bool) (string, bool) {
+	s.partial += message
+	if s.outputPrefix != "" {
+		return s.unbracket()
+	}
+	if s.framed || !complete(s.partial) {
+		s.armQuiet()
+		return "", false
+	}
+	text := s.partial
+	s.partial = ""
+	return text, true
+}
+
+func (s *ServerState) armQuiet() {
+	if s.quietTimer != nil {
+		s.quietTimer.Stop()
+	}
+	seq := s.awaitSeq
+	s.quietTimer = time.AfterFunc(assembleQuiet, func() {
+		select {
+		case s.quietExpired <- seq:
+		default:
+		}
+	})
+}
+
+// processEndOfRecord handles a GA or EOR from the MUSH, which completes the
+// response collected so far, unless the brackets say where it ends. The
+// caller holds s.mu.
+func (s *ServerState) processEndOfRecord() {
+	s.framed = true
+	if s.partial == "" || s.outputPrefix != "" {
+		return
+	}
+	text := s.partial
+	s.partial = ""
+	s.processResponse(text)
+}
+
+// processQuiet parses a response which never completed, once the MUSH has
+// been quiet for assembleQuiet, so the parsers can say what's wrong with it.
+// The caller holds s.mu.
+func (s *ServerState) processQuiet(seq uint64) {
+	if seq != s.awaitSeq || s.partial == "" {
+		return
+	}
+	text := s.partial
+	s.partial = ""
+	if s.outputPrefix != "" {
+		// The suffix never came, but what follows the prefix is ours.
+		if _, output, ok := strings.Cut(text, s.outputPrefix); ok {
+			text = strings.TrimLeft(output, "\r\n")
+		}
+	}
+	s.processResponse(text)
+}
+
+// processResponse handles the complete response to a WHO, location lookup or
+// probe.
+// An error reply is mostly caught as it arrives, but with --output-brackets it
+// only shows once they are taken off. The caller holds s.mu.
+func (s *ServerState) processResponse(text string) {
+	if s.isErrorReply(text) {
+		s.commandFailed(text)
+		return
+	}
+	switch s.currentState {
+	case STATE_AWAIT_WHO:
+		s.awaitTimeouts = 0
+		s.setState(STATE_IDLE)
+		s.processWho(text)
+		s.queueLookups()
+		s.dispatch()
+	case STATE_AWAIT_LOC:
+		s.awaitTimeouts = 0
+		s.setState(STATE_IDLE)
+		s.processLocation(text)
+		s.dispatch()
+	case STATE_PROBE:
+		s.processProbe(text)
+	}
+}
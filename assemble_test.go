@@ -0,0 +1,137 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// whoPlayers feeds the chunks of a WHO to a state machine awaiting it and
+// returns the players it parsed. The rooms are known, so nothing else is
+// sent after the WHO.
+func whoPlayers(t *testing.T, chunks ...string) []MushPlayer {
+	t.Helper()
+	s := newTestState(t)
+	for _, dbref := range []string{"#10", "#11"} {
+		s.locations.Set(dbref, "Room "+dbref)
+	}
+	for i := 100; i < 112; i++ {
+		s.locations.Set(fmt.Sprintf("#%d", i), fmt.Sprintf("Room #%d", i))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentState = STATE_AWAIT_WHO
+	for _, chunk := range chunks {
+		s.processMessage(chunk)
+	}
+	if s.currentState == STATE_AWAIT_WHO {
+		t.Fatalf("%q is still incomplete", chunks)
+	}
+	if s.lastError != nil {
+		t.Errorf("%q: %s", chunks, s.lastError.Message)
+	}
+	players := make([]MushPlayer, len(s.mushState.Players))
+	for i, player := range s.mushState.Players {
+		players[i] = *player
+		players[i].locations = nil
+	}
+	return players
+}
+
+func TestWhoSplitAnywhere(t *testing.T) {
+	for _, fixture := range []string{"who.txt", "who_many.txt"} {
+		who := readFixture(t, fixture)
+		want := whoPlayers(t, who)
+		if len(want) == 0 {
+			t.Fatalf("%s has no players", fixture)
+		}
+		for i := 1; i < len(who); i++ {
+			if got := whoPlayers(t, who[:i], who[i:]); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s split at byte %d (%q|%q): got %+v, want %+v", fixture, i, who[i-3:i], who[i:i+3], got, want)
+			}
+		}
+		chunks := make([]string, len(who))
+		for i := range who {
+			chunks[i] = who[i : i+1]
+		}
+		if got := whoPlayers(t, chunks...); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s byte by byte: got %+v, want %+v", fixture, got, want)
+		}
+	}
+}
+
+func TestIsCompleteWho(t *testing.T) {
+	for _, tc := range []struct {
+		text     string
+		complete bool
+	}{
+		{"Player Name\r\n3 Players logged in.\r\n", true},
+		{"Player Name\n3 Players logged in.\n", true},
+		{"Player Name\r3 Players logged in.\r", true},
+		{"Player Name\r\n3 Players logged in.", false},
+		{"Player Name\r\n3 Players logg", false},
+		{"Player Name\r\nWalker 00:10 1m\r\n", false},
+	} {
+		if got := isCompleteWho(tc.text); got != tc.complete {
+			t.Errorf("isCompleteWho(%q) = %v, want %v", tc.text, got, tc.complete)
+		}
+	}
+}
+
+func TestLocationSplitAnywhere(t *testing.T) {
+	reply := "You say, \"#10\"Town Square\"\r\n"
+	for i := 1; i < len(reply); i++ {
+		s := newTestState(t)
+		s.mu.Lock()
+		s.currentState = STATE_AWAIT_LOC
+		s.inFlight = locationCommand("#10", s.config.AwaitTimeout)
+		s.processMessage(reply[:i])
+		s.processMessage(reply[i:])
+		state, lastError := s.currentState, s.lastError
+		s.mu.Unlock()
+		if name, ok := s.locations.Lookup("#10"); !ok || name != "Town Square" || state != STATE_IDLE || lastError != nil {
+			t.Errorf("split at byte %d (%q|%q): %q, %v in state %s, error %v", i, reply[:i], reply[i:], name, ok, state, lastError)
+		}
+	}
+}
+
+// TestFramedWho waits for the end of record once the MUSH was seen to send
+// them, even if the WHO already looks complete.
+func TestFramedWho(t *testing.T) {
+	s := newTestState(t)
+	s.locations.Set("#10", "Town Square")
+	s.locations.Set("#11", "The Docks")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentState = STATE_IDLE
+	// The prompt after the login.
+	s.processEndOfRecord()
+
+	s.currentState = STATE_AWAIT_WHO
+	who := readFixture(t, "who.txt")
+	s.processMessage(who)
+	if s.currentState != STATE_AWAIT_WHO {
+		t.Fatalf("parsed before the end of record, in state %s", s.currentState)
+	}
+	s.processEndOfRecord()
+	if s.currentState != STATE_IDLE || len(s.mushState.Players) != 3 || s.lastError != nil {
+		t.Errorf("after the end of record: state %s, %d players, error %v", s.currentState, len(s.mushState.Players), s.lastError)
+	}
+}
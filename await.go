@@ -0,0 +1,87 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// startAwait arms the timeout for the response to the command which was just
+// sent. setState disarms it, so it can only fire while the state machine
+// still waits for that very response. The caller holds s.mu.
+func (s *ServerState) startAwait(timeout time.Duration) {
+	s.awaitSeq++
+	seq := s.awaitSeq
+	s.awaitTimer = time.AfterFunc(timeout, func() {
+		select {
+		case s.awaitExpired <- seq:
+		default:
+		}
+	})
+}
+
+func (s *ServerState) stopAwait() {
+	if s.awaitTimer != nil {
+		s.awaitTimer.Stop()
+		s.awaitTimer = nil
+	}
+}
+
+// processAwaitTimeout gives up on a response the MUSH never sent, going back
+// to idle so the next tick can try again. After --await-max-timeouts in a row
+// the connection is assumed to be broken and dialed again. The caller holds
+// s.mu.
+func (s *ServerState) processAwaitTimeout(seq uint64) {
+	// The timer may have fired just as the response arrived.
+	if seq != s.awaitSeq {
+		return
+	}
+	switch s.currentState {
+	case STATE_KEEPALIVE, STATE_SETUP:
+		// These usually have no response at all.
+		s.setState(STATE_IDLE)
+		s.dispatch()
+		return
+	case STATE_LOGGING_IN:
+		s.loginTimedOut()
+		return
+	case STATE_PROBE:
+		s.probeTimedOut()
+		return
+	case STATE_AWAIT_WHO, STATE_AWAIT_LOC:
+	default:
+		return
+	}
+	s.awaitTimeouts++
+	// Whatever else was to be looked up waits for the next WHO.
+	s.dropLookups()
+	s.metrics.RecordAwaitTimeout()
+	message := fmt.Sprintf("no response in %s, %d in a row", s.currentState, s.awaitTimeouts)
+	s.logger.Println("Timed out:", message)
+	s.recordError("timeout: " + message)
+
+	if s.config.MaxTimeouts > 0 && s.awaitTimeouts >= s.config.MaxTimeouts {
+		s.logger.Println("Too many timeouts, reconnecting.")
+		s.awaitTimeouts = 0
+		s.disconnect()
+		return
+	}
+	s.setState(STATE_IDLE)
+	s.dispatch()
+}
@@ -0,0 +1,135 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	badgeColorUp   = "#4c1"
+	badgeColorDown = "#9f9f9f"
+	// Rough average glyph width of 11px Verdana, which shields-style badges
+	// use, plus the padding on either side of a text.
+	badgeCharWidth = 7
+	badgePadding   = 10
+)
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`
+
+// renderBadge draws a shields-style badge whose parts grow with their text.
+func renderBadge(label string, message string, color string) string {
+	labelWidth := len(label)*badgeCharWidth + badgePadding
+	messageWidth := len(message)*badgeCharWidth + badgePadding
+	return fmt.Sprintf(badgeTemplate,
+		labelWidth+messageWidth,
+		labelWidth,
+		messageWidth,
+		html.EscapeString(label),
+		html.EscapeString(message),
+		color,
+		labelWidth/2,
+		labelWidth+messageWidth/2,
+	)
+}
+
+func (s *ServerState) serveBadge(w http.ResponseWriter, r *http.Request) {
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		label = "players online"
+	}
+
+	s.mu.RLock()
+	count := 0
+	for _, player := range s.mushState.Players {
+		if player != nil {
+			count++
+		}
+	}
+	connected := isLoggedIn(s.currentState)
+	s.mu.RUnlock()
+
+	color := badgeColorUp
+	if !connected {
+		color = badgeColorDown
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	fmt.Fprint(w, renderBadge(label, strconv.Itoa(count), color))
+}
+
+// ShieldsBadge is the shields.io endpoint badge schema, see
+// https://shields.io/badges/endpoint-badge
+type ShieldsBadge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+func shieldsBadge(count int, connected bool, lastWho time.Time, staleAfter time.Duration, now time.Time) ShieldsBadge {
+	badge := ShieldsBadge{
+		SchemaVersion: 1,
+		Label:         "MUSH",
+		Message:       fmt.Sprintf("%d online", count),
+		Color:         "brightgreen",
+	}
+	switch {
+	case !connected:
+		badge.Message = "offline"
+		badge.Color = "red"
+	case lastWho.IsZero() || now.Sub(lastWho) > staleAfter:
+		badge.Color = "yellow"
+	}
+	return badge
+}
+
+func (s *ServerState) serveShieldsBadge(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	count := 0
+	for _, player := range s.mushState.Players {
+		if player != nil {
+			count++
+		}
+	}
+	badge := shieldsBadge(count, isLoggedIn(s.currentState), s.lastSuccessfulWho, s.config.StaleAfter, s.now())
+	s.mu.RUnlock()
+
+	jsonBody, err := json.Marshal(badge)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
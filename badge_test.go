@@ -0,0 +1,70 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShieldsBadge(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		connected bool
+		lastWho   time.Time
+		message   string
+		color     string
+	}{
+		{"fresh", true, now.Add(-time.Minute), "7 online", "brightgreen"},
+		{"at the threshold", true, now.Add(-5 * time.Minute), "7 online", "brightgreen"},
+		{"stale", true, now.Add(-6 * time.Minute), "7 online", "yellow"},
+		{"no WHO yet", true, time.Time{}, "7 online", "yellow"},
+		{"offline", false, now.Add(-time.Minute), "offline", "red"},
+		{"offline and stale", false, now.Add(-time.Hour), "offline", "red"},
+	} {
+		badge := shieldsBadge(7, tc.connected, tc.lastWho, 5*time.Minute, now)
+		want := ShieldsBadge{SchemaVersion: 1, Label: "MUSH", Message: tc.message, Color: tc.color}
+		if badge != want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, badge, want)
+		}
+	}
+}
+
+func TestServeShieldsBadge(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker"}, &MushPlayer{Name: "Rhee"})
+	response := serve(s.handler(s.routes()), httptest.NewRequest(http.MethodGet, "/api/badge", nil))
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d with %s", response.Code, response.Header().Get("Content-Type"))
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(response.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"schemaVersion": 1.0, "label": "MUSH", "message": "2 online", "color": "brightgreen"}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v", key, fields[key], value)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// StateBroadcaster fans serialized state snapshots out to any number of
+// subscribers. Each subscriber channel holds at most one pending snapshot;
+// a newer snapshot replaces an unread one, so slow subscribers never block
+// the publisher.
+type StateBroadcaster struct {
+	mu          sync.Mutex
+	latest      []byte
+	subscribers map[chan []byte]struct{}
+}
+
+func NewStateBroadcaster() *StateBroadcaster {
+	return &StateBroadcaster{
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+// Subscribe returns a channel which immediately holds the latest snapshot,
+// if any, followed by every later change.
+func (b *StateBroadcaster) Subscribe() chan []byte {
+	ch := make(chan []byte, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.latest != nil {
+		ch <- b.latest
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *StateBroadcaster) Unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// Publish sends the snapshot to all subscribers and reports whether it
+// differed from the previous one. Identical snapshots are not sent again.
+func (b *StateBroadcaster) Publish(snapshot []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.latest != nil && bytes.Equal(b.latest, snapshot) {
+		return false
+	}
+	b.latest = snapshot
+	for ch := range b.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- snapshot
+	}
+	return true
+}
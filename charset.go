@@ -0,0 +1,96 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cp437High is the upper half of code page 437, the character set of DOS and
+// many old MUDs. The lower half is ASCII.
+const cp437High = "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0"
+
+// Charset converts between UTF-8 and the single-byte character set the MUSH
+// speaks. A nil Charset, or one without tables, is UTF-8 and passes text
+// through.
+type Charset struct {
+	decode *[256]rune
+	encode map[rune]byte
+}
+
+func NewCharset(name string) (*Charset, error) {
+	var decode [256]rune
+	switch name {
+	case "", "utf8":
+		return &Charset{}, nil
+	case "latin1":
+		for i := range decode {
+			decode[i] = rune(i)
+		}
+	case "cp437":
+		for i := 0; i < 0x80; i++ {
+			decode[i] = rune(i)
+		}
+		i := 0x80
+		for _, r := range cp437High {
+			decode[i] = r
+			i++
+		}
+	default:
+		return nil, fmt.Errorf("unknown charset %s", name)
+	}
+	encode := make(map[rune]byte, len(decode))
+	for i, r := range decode {
+		encode[r] = byte(i)
+	}
+	return &Charset{decode: &decode, encode: encode}, nil
+}
+
+// Decode turns text received from the MUSH into UTF-8.
+func (c *Charset) Decode(text string) string {
+	if c == nil || c.decode == nil {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); i++ {
+		b.WriteRune(c.decode[text[i]])
+	}
+	return b.String()
+}
+
+// Encode turns a command into the MUSH's character set. Characters it
+// doesn't have are sent as a question mark.
+func (c *Charset) Encode(text string) string {
+	if c == nil || c.decode == nil {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		if encoded, ok := c.encode[r]; ok {
+			b.WriteByte(encoded)
+		} else {
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCharsetDecode(t *testing.T) {
+	for _, tc := range []struct {
+		charset string
+		text    string
+		want    string
+	}{
+		{"utf8", "Caf\xc3\xa9", "Café"},
+		// UTF-8 passes through as it is, even if it isn't valid.
+		{"utf8", "Caf\xe9", "Caf\xe9"},
+		{"latin1", "Caf\xe9 \xdcber \xff", "Café Über ÿ"},
+		{"latin1", "\x00\x7f", "\x00\x7f"},
+		// Latin-1 has no characters at 0x80 to 0x9f, which are the C1
+		// control codes.
+		{"latin1", "\x80\x9f", "\u0080\u009f"},
+		{"latin1", "\xa0", "\u00a0"},
+		{"cp437", "Caf\x82", "Café"},
+		{"cp437", "\x80\x9e\x9f", "Ç₧ƒ"},
+		{"cp437", "\xb0\xc4\xdb", "░─█"},
+		{"cp437", "\xe1\xf8\xfe\xff", "ß°■\u00a0"},
+	} {
+		charset, err := NewCharset(tc.charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := charset.Decode(tc.text); got != tc.want {
+			t.Errorf("%s: Decode(%q) = %q, want %q", tc.charset, tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestCharsetEncode(t *testing.T) {
+	for _, tc := range []struct {
+		charset string
+		text    string
+		want    string
+	}{
+		{"utf8", "Café ☃", "Café ☃"},
+		{"latin1", "Café Über ÿ", "Caf\xe9 \xdcber \xff"},
+		// Neither has a snowman or an emoji.
+		{"latin1", "say ☃ 😀", "say ? ?"},
+		{"latin1", "€", "?"},
+		{"cp437", "Café ░", "Caf\x82 \xb0"},
+		{"cp437", "say Ã ☃", "say ? ?"},
+	} {
+		charset, err := NewCharset(tc.charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := charset.Encode(tc.text); got != tc.want {
+			t.Errorf("%s: Encode(%q) = %q, want %q", tc.charset, tc.text, got, tc.want)
+		}
+	}
+}
+
+// TestCharsetRoundTrip decodes every byte and encodes it back.
+func TestCharsetRoundTrip(t *testing.T) {
+	for _, name := range []string{"latin1", "cp437"} {
+		charset, err := NewCharset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := make(map[rune]bool)
+		for i := 0; i < 256; i++ {
+			text := string([]byte{byte(i)})
+			utf := charset.Decode(text)
+			if !utf8.ValidString(utf) || utf8.RuneCountInString(utf) != 1 {
+				t.Errorf("%s: byte %#x decodes to %q", name, i, utf)
+			}
+			decoded[[]rune(utf)[0]] = true
+			if back := charset.Encode(utf); back != text {
+				t.Errorf("%s: byte %#x comes back as %q", name, i, back)
+			}
+		}
+		if len(decoded) != 256 {
+			t.Errorf("%s: %d characters for 256 bytes", name, len(decoded))
+		}
+	}
+	if _, err := NewCharset("koi8-r"); err == nil {
+		t.Error("an unknown charset was accepted")
+	}
+}
+
+func TestLatin1RoomName(t *testing.T) {
+	mush := newFakeMUSH(t)
+	mush.rooms["#11"] = "Caf\xe9 du Port"
+	ts := startServer(t, mush, serverEnv{}, "--charset", "latin1")
+
+	var body string
+	waitFor(t, "the room name", func() bool {
+		_, body = ts.getText("/api/v2")
+		return strings.Contains(body, "Café du Port")
+	})
+	if !utf8.ValidString(body) {
+		t.Errorf("/api/v2 is no valid UTF-8: %q", body)
+	}
+	var status StatusV2
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Players[1].Location != "Café du Port" {
+		t.Errorf("Sam is in %q", status.Players[1].Location)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// debugLogSize is the number of raw telnet messages kept for /debug/state.
+const debugLogSize = 20
+
+type RawMessage struct {
+	Time time.Time `json:"time"`
+	// Direction is either "received" or "sent".
+	Direction string `json:"direction"`
+	Text      string `json:"text"`
+}
+
+// MessageLog keeps the most recent raw telnet messages in both directions.
+type MessageLog struct {
+	mu       sync.RWMutex
+	messages []RawMessage
+	size     int
+}
+
+func NewMessageLog(size int) *MessageLog {
+	return &MessageLog{
+		messages: make([]RawMessage, 0, size),
+		size:     size,
+	}
+}
+
+func (l *MessageLog) Add(message RawMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, message)
+	if len(l.messages) > l.size {
+		l.messages = append(l.messages[:0:0], l.messages[len(l.messages)-l.size:]...)
+	}
+}
+
+// Recent returns the retained messages, oldest first.
+func (l *MessageLog) Recent() []RawMessage {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]RawMessage(nil), l.messages...)
+}
+
+type DebugError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+type DebugState struct {
+	State             string       `json:"state"`
+	StateSince        time.Time    `json:"stateSince"`
+	SecondsInState    float64      `json:"secondsInState"`
+	UnknownLocations  []string     `json:"unknownLocations"`
+	LocationCacheSize int          `json:"locationCacheSize"`
+	Messages          []RawMessage `json:"messages"`
+	LastError         *DebugError  `json:"lastError"`
+	TelnetOptions     []string     `json:"telnetOptions"`
+	Framed            bool         `json:"framed"`
+	Backoff           DebugBackoff `json:"backoff"`
+}
+
+// DebugBackoff tells why reconnects are as far apart as they are.
+type DebugBackoff struct {
+	// Attempts is the number of reconnects since the delay last started
+	// over.
+	Attempts int `json:"attempts"`
+	// DelaySeconds is the delay before the last or upcoming reconnect.
+	DelaySeconds float64 `json:"delaySeconds"`
+	// StableAfterSeconds is how long a session has to last for the delay
+	// to start over.
+	StableAfterSeconds float64 `json:"stableAfterSeconds"`
+}
+
+// recordMessage keeps a raw telnet message for debugging, and writes it to
+// the --trace-telnet file, without the credentials in the connect command
+// or any other line sent to log in. The caller holds s.mu.
+func (s *ServerState) recordMessage(direction string, text string) {
+	if direction == "sent" && s.currentState == STATE_LOGGING_IN {
+		text = "[redacted]"
+	} else {
+		text = s.redactLogin(text)
+	}
+	s.messages.Add(RawMessage{Time: s.now(), Direction: direction, Text: text})
+	s.trace.Add(s.name, direction, text)
+}
+
+// recordError remembers the last thing that went wrong in the state
+// machine. The caller holds s.mu.
+func (s *ServerState) recordError(message string) {
+	s.lastError = &DebugError{Time: s.now(), Message: message}
+}
+
+// withDebug lets requests through if --debug is given and requires admin
+// auth otherwise.
+func (s *ServerState) withDebug(next http.HandlerFunc) http.HandlerFunc {
+	if s.config.Debug {
+		return next
+	}
+	return s.withAdminAuth(next)
+}
+
+func (s *ServerState) debugState() DebugState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names, unknown := s.locations.Snapshot()
+	options := []string{}
+	if s.telnetOptions != nil {
+		options = s.telnetOptions.List()
+	}
+	return DebugState{
+		State:             s.currentState,
+		StateSince:        s.stateSince,
+		SecondsInState:    s.now().Sub(s.stateSince).Seconds(),
+		UnknownLocations:  unknown,
+		LocationCacheSize: len(names),
+		Messages:          s.messages.Recent(),
+		LastError:         s.lastError,
+		TelnetOptions:     options,
+		Framed:            s.framed,
+		Backoff: DebugBackoff{
+			Attempts:           s.backoff.attempts,
+			DelaySeconds:       s.backoff.last.Seconds(),
+			StableAfterSeconds: s.config.StableAfter.Seconds(),
+		},
+	}
+}
+
+func (s *ServerState) serveDebugState(w http.ResponseWriter, r *http.Request) {
+	jsonBody, err := json.MarshalIndent(s.debugState(), "", "  ")
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
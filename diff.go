@@ -0,0 +1,156 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// diffJournalSize is the number of revisions /api/diff can diff against.
+const diffJournalSize = 100
+
+// SnapshotJournal keeps the players of the most recent revisions, by name,
+// so clients can fetch only what changed since the revision they have. It
+// is guarded by ServerState.mu.
+type SnapshotJournal struct {
+	snapshots map[uint64]map[string]journalPlayer
+	oldest    uint64
+	size      int
+}
+
+// journalPlayer is a player as served, with the dbref of the location. A
+// location whose name was only looked up since, or changed, is still the
+// same room.
+type journalPlayer struct {
+	PlayerV2
+	location MushLocation
+}
+
+func NewSnapshotJournal(size int) *SnapshotJournal {
+	return &SnapshotJournal{
+		snapshots: make(map[uint64]map[string]journalPlayer),
+		size:      size,
+	}
+}
+
+// Add records the players for a revision. Revisions are added in order, so
+// the oldest one is dropped once the journal is full.
+func (j *SnapshotJournal) Add(revision uint64, players []*MushPlayer) {
+	snapshot := make(map[string]journalPlayer, len(players))
+	for _, player := range players {
+		snapshot[player.Name] = journalPlayer{playerV2(player, false), player.Location}
+	}
+	j.snapshots[revision] = snapshot
+	if len(j.snapshots) == 1 {
+		j.oldest = revision
+	}
+	for len(j.snapshots) > j.size {
+		delete(j.snapshots, j.oldest)
+		j.oldest++
+	}
+}
+
+func (j *SnapshotJournal) Get(revision uint64) (map[string]journalPlayer, bool) {
+	snapshot, ok := j.snapshots[revision]
+	return snapshot, ok
+}
+
+type DiffResponse struct {
+	Revision uint64 `json:"revision"`
+	// Full is set when the requested revision is no longer known. Players
+	// then holds the whole list instead of a diff.
+	Full    bool       `json:"full"`
+	Players []PlayerV2 `json:"players,omitempty"`
+	Added   []PlayerV2 `json:"added"`
+	Removed []string   `json:"removed"`
+	// Moved are the players who went to another room.
+	Moved []PlayerV2 `json:"moved"`
+	// Changed are the players who stayed in their room but are served
+	// differently, e.g. once its name was looked up or with a new @doing.
+	Changed []PlayerV2 `json:"changed"`
+}
+
+// diffSnapshots lists the players added, removed, moved and changed between
+// two snapshots, each sorted by name.
+func diffSnapshots(old map[string]journalPlayer, new map[string]journalPlayer) DiffResponse {
+	diff := DiffResponse{
+		Added:   make([]PlayerV2, 0),
+		Removed: make([]string, 0),
+		Moved:   make([]PlayerV2, 0),
+		Changed: make([]PlayerV2, 0),
+	}
+	for name, player := range new {
+		previous, ok := old[name]
+		if !ok {
+			diff.Added = append(diff.Added, player.PlayerV2)
+		} else if previous.location != player.location {
+			diff.Moved = append(diff.Moved, player.PlayerV2)
+		} else if previous.PlayerV2 != player.PlayerV2 {
+			diff.Changed = append(diff.Changed, player.PlayerV2)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	byName := func(a, b PlayerV2) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	}
+	slices.SortFunc(diff.Added, byName)
+	slices.SortFunc(diff.Moved, byName)
+	slices.SortFunc(diff.Changed, byName)
+	slices.SortFunc(diff.Removed, func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	return diff
+}
+
+func (s *ServerState) serveDiff(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "since must be a revision number")
+		return
+	}
+
+	s.mu.RLock()
+	current, _ := s.journal.Get(s.revision)
+	var response DiffResponse
+	if old, ok := s.journal.Get(since); ok {
+		response = diffSnapshots(old, current)
+	} else {
+		response = diffSnapshots(nil, current)
+		response.Full = true
+		response.Players = response.Added
+		response.Added = make([]PlayerV2, 0)
+	}
+	response.Revision = s.revision
+	s.mu.RUnlock()
+
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
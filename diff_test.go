@@ -0,0 +1,151 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	handler := s.handler(s.routes())
+	s.locations.Set("#10", "Town Square")
+	revision := func() uint64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.revision
+	}
+
+	setPlayers(s,
+		&MushPlayer{Name: "Rhee", Location: "#10"},
+		&MushPlayer{Name: "Sam", Location: "#11"},
+		&MushPlayer{Name: "Walker", Location: "#10"},
+	)
+	first := revision()
+	// Only the name of Sam's room changes.
+	s.locations.Set("#11", "The Docks")
+	setPlayers(s,
+		&MushPlayer{Name: "Rhee", Location: "#10"},
+		&MushPlayer{Name: "Sam", Location: "#11"},
+		&MushPlayer{Name: "Walker", Location: "#10"},
+	)
+	resolved := revision()
+	if resolved == first {
+		t.Fatal("no new revision for the looked up room")
+	}
+	setPlayers(s,
+		&MushPlayer{Name: "Ann", Location: "#10"},
+		&MushPlayer{Name: "Rhee", Location: "#11"},
+		&MushPlayer{Name: "Sam", Location: "#11"},
+	)
+
+	for _, tc := range []struct {
+		since uint64
+		want  DiffResponse
+	}{
+		{first, DiffResponse{
+			Added:   []PlayerV2{{Name: "Ann", Location: "Town Square"}},
+			Removed: []string{"Walker"},
+			Moved:   []PlayerV2{{Name: "Rhee", Location: "The Docks"}},
+			Changed: []PlayerV2{{Name: "Sam", Location: "The Docks"}},
+		}},
+		{resolved, DiffResponse{
+			Added:   []PlayerV2{{Name: "Ann", Location: "Town Square"}},
+			Removed: []string{"Walker"},
+			Moved:   []PlayerV2{{Name: "Rhee", Location: "The Docks"}},
+			Changed: []PlayerV2{},
+		}},
+		{revision(), DiffResponse{
+			Added:   []PlayerV2{},
+			Removed: []string{},
+			Moved:   []PlayerV2{},
+			Changed: []PlayerV2{},
+		}},
+		{0, DiffResponse{
+			Full: true,
+			Players: []PlayerV2{
+				{Name: "Ann", Location: "Town Square"},
+				{Name: "Rhee", Location: "The Docks"},
+				{Name: "Sam", Location: "The Docks"},
+			},
+			Added:   []PlayerV2{},
+			Removed: []string{},
+			Moved:   []PlayerV2{},
+			Changed: []PlayerV2{},
+		}},
+	} {
+		path := fmt.Sprintf("/api/diff?since=%d", tc.since)
+		response := serve(handler, httptest.NewRequest(http.MethodGet, path, nil))
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s answered %d", path, response.Code)
+		}
+		var diff DiffResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &diff); err != nil {
+			t.Fatal(err)
+		}
+		tc.want.Revision = revision()
+		if !reflect.DeepEqual(diff, tc.want) {
+			t.Errorf("%s is %+v, want %+v", path, diff, tc.want)
+		}
+	}
+}
+
+// TestDiffChanged brings a client which has a room's dbref, or an old
+// @doing, up to date without a full fetch.
+func TestDiffChanged(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	handler := s.handler(s.routes())
+
+	setPlayers(s,
+		&MushPlayer{Name: "Rhee", Location: "#10", Doing: "Fishing"},
+		&MushPlayer{Name: "Sam", Location: "#11"},
+	)
+	var before StatusV2
+	json.Unmarshal(serve(handler, httptest.NewRequest(http.MethodGet, "/api/v2", nil)).Body.Bytes(), &before)
+	if before.Players[1].Location != "#11" {
+		t.Fatalf("unresolved room served as %q", before.Players[1].Location)
+	}
+	s.mu.RLock()
+	since := s.revision
+	s.mu.RUnlock()
+
+	s.locations.Set("#11", "The Docks")
+	setPlayers(s,
+		&MushPlayer{Name: "Rhee", Location: "#10", Doing: "Napping"},
+		&MushPlayer{Name: "Sam", Location: "#11"},
+	)
+	response := serve(handler, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/diff?since=%d", since), nil))
+	var diff DiffResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &diff); err != nil {
+		t.Fatal(err)
+	}
+	want := []PlayerV2{
+		{Name: "Rhee", Location: "#10", Doing: "Napping"},
+		{Name: "Sam", Location: "The Docks"},
+	}
+	if len(diff.Moved) != 0 || !reflect.DeepEqual(diff.Changed, want) {
+		t.Errorf("moved %+v and changed %+v, want only %+v changed", diff.Moved, diff.Changed, want)
+	}
+}
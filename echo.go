@@ -0,0 +1,39 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "strings"
+
+// stripEcho removes the MUSH's echo of the last command from the start of
+// the first message after it was sent, and reports whether anything is left
+// to process. Later messages are left alone, so a line which just happens
+// to equal the command isn't lost. With --echo-match prefix, a line which
+// starts with the command counts as its echo too. The caller holds s.mu.
+func (s *ServerState) stripEcho(message string) (string, bool) {
+	command := s.lastCommand
+	s.lastCommand = ""
+	if command == "" || s.config.EchoMatch == "off" {
+		return message, true
+	}
+	line, rest, _ := strings.Cut(message, "\n")
+	line = strings.TrimRight(line, "\r")
+	if line != command && (s.config.EchoMatch != "prefix" || !strings.HasPrefix(line, command)) {
+		return message, true
+	}
+	return rest, strings.TrimSpace(rest) != ""
+}
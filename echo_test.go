@@ -0,0 +1,80 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestStripEcho(t *testing.T) {
+	for _, tc := range []struct {
+		match   string
+		command string
+		message string
+		rest    string
+		left    bool
+	}{
+		{"exact", "WHO", "WHO\r\nPlayer Name\r\n", "Player Name\r\n", true},
+		{"exact", "WHO", "WHO\r\n", "", false},
+		{"exact", "WHO", "Player Name\r\n", "Player Name\r\n", true},
+		{"exact", "WHO", "WHO is there\r\nPlayer Name\r\n", "WHO is there\r\nPlayer Name\r\n", true},
+		{"exact", "", "WHO\r\n", "WHO\r\n", true},
+		{"prefix", "WHO", "WHO is there\r\nPlayer Name\r\n", "Player Name\r\n", true},
+		{"prefix", "WHO", "Player Name\r\n", "Player Name\r\n", true},
+		{"off", "WHO", "WHO\r\nPlayer Name\r\n", "WHO\r\nPlayer Name\r\n", true},
+	} {
+		s := newTestState(t, "--echo-match", tc.match)
+		s.lastCommand = tc.command
+		rest, left := s.stripEcho(tc.message)
+		if rest != tc.rest || left != tc.left {
+			t.Errorf("%s %q after %q: %q, %v, want %q, %v", tc.match, tc.message, tc.command, rest, left, tc.rest, tc.left)
+		}
+		// Only the first message after a command can be its echo.
+		if rest, _ := s.stripEcho(tc.message); rest != tc.message {
+			t.Errorf("%s %q: stripped again", tc.match, tc.message)
+		}
+	}
+}
+
+// TestEcho polls servers which echo each command, and ones which don't.
+// The location lookups are quoted, so an echo left in would be taken for
+// their answer.
+func TestEcho(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		echo  string
+		match string
+	}{
+		{"no echo", "", "exact"},
+		{"no echo with prefix", "", "prefix"},
+		{"echo", "%s\r\n", "exact"},
+		{"annotated echo", "%s [echo]\r\n", "prefix"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mush := newFakeMUSH(t)
+			mush.echo = tc.echo
+			ts := startServer(t, mush, serverEnv{}, "--echo-match", tc.match)
+			ts.waitForPlayers()
+			var stats Stats
+			ts.get("/api/stats", &stats)
+			if stats.LocationCacheSize != 2 {
+				t.Errorf("%d locations cached, want 2", stats.LocationCacheSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "strings"
+
+// isErrorReply reports whether the MUSH answered the command in flight with
+// one of its error messages, e.g. because the connect command left us
+// without the permissions to run it.
+func (s *ServerState) isErrorReply(message string) bool {
+	return s.errorMatch != nil && s.errorMatch.MatchString(message)
+}
+
+// commandFailed handles an error reply to a WHO or location lookup and moves
+// on to the next command. A lookup which fails like this would fail again
+// after every WHO, so the location goes to the negative cache. A failed WHO
+// counts toward --who-failure-limit like one which couldn't be parsed, and
+// may end the session. The caller holds s.mu.
+func (s *ServerState) commandFailed(message string) {
+	reply, _, _ := strings.Cut(message, "\n")
+	reply = strings.TrimSpace(reply)
+	failed := s.currentState
+	s.awaitTimeouts = 0
+	s.setState(STATE_IDLE)
+	switch failed {
+	case STATE_AWAIT_WHO:
+		s.logger.Println("WHO failed:", reply)
+		s.whoFailed("WHO failed: " + reply)
+	case STATE_AWAIT_LOC:
+		s.logger.Printf("Looking up %s failed: %s", s.inFlight.Location, reply)
+		s.recordError("location lookup failed: " + reply)
+		s.locations.Fail(s.inFlight.Location)
+		s.metrics.RecordLocationResult(false)
+	case STATE_PROBE:
+		// The MUSH answered, so it isn't hung, but the probe needs fixing.
+		s.logger.Println("Probe failed:", reply)
+		s.recordError("probe failed: " + reply)
+	}
+	s.dispatch()
+}
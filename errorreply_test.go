@@ -0,0 +1,65 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWhoErrorReplies answers WHO with an error. Those count toward
+// --who-failure-limit like WHOs which can't be parsed.
+func TestWhoErrorReplies(t *testing.T) {
+	for _, tc := range []struct {
+		errors    int
+		reconnect bool
+	}{
+		{2, false},
+		{3, true},
+	} {
+		mush := newFakeMUSH(t)
+		failures := 0
+		mush.Respond(func(line string) (string, bool) {
+			if strings.EqualFold(line, "WHO") && failures < tc.errors {
+				failures++
+				return mush.bracket("Huh?  (Type \"help\" for help.)\r\n"), true
+			}
+			return "", false
+		})
+		ts := startServer(t, mush, serverEnv{tick: 20 * time.Millisecond}, "--who-failure-limit", "3")
+		ts.waitForPlayers()
+
+		var stats Stats
+		ts.get("/api/stats", &stats)
+		dials, logins := mush.Counts()
+		if reconnected := dials == 2 && logins == 2; reconnected != tc.reconnect {
+			t.Errorf("%d error replies: %d dials and %d logins", tc.errors, dials, logins)
+		}
+		want := uint64(0)
+		if tc.reconnect {
+			want = 1
+		}
+		if stats.ConnectionErrors != want {
+			t.Errorf("%d error replies: %d connection errors, want %d", tc.errors, stats.ConnectionErrors, want)
+		}
+		if stats.FailedWhosInRow != 0 {
+			t.Errorf("%d error replies: %d failures left after a good WHO", tc.errors, stats.FailedWhosInRow)
+		}
+	}
+}
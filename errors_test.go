@@ -0,0 +1,109 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// checkErrorBody fails unless response is a JSON error with status.
+func checkErrorBody(t *testing.T, name string, response *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if response.Code != status {
+		t.Errorf("%s: got %d, want %d", name, response.Code, status)
+		return
+	}
+	if got := response.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s: Content-Type %q", name, got)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Errorf("%s: %v in %q", name, err, response.Body)
+		return
+	}
+	if body.Error.Code != errorCode(status) || body.Error.Message == "" {
+		t.Errorf("%s: error %+v", name, body.Error)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	s := newTestState(t, "--api-token", "secret", "--admin-user", "admin", "--admin-pass", "pass")
+	handler := s.handler(s.routes())
+	public := newTestState(t)
+	withoutAdmin := public.handler(public.routes())
+	// runServer sets up the rate limiter from --rate-limit.
+	public.rateLimiter = NewRateLimiter(1, 1)
+
+	request := func(method string, path string, token bool, admin bool) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		if token {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		if admin {
+			r.SetBasicAuth("admin", "pass")
+		}
+		return r
+	}
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		request *http.Request
+		status  int
+	}{
+		{"bad callback", handler, request(http.MethodGet, "/api?callback=a(1)", true, false), http.StatusBadRequest},
+		{"no token", handler, request(http.MethodGet, "/api", false, false), http.StatusUnauthorized},
+		{"wrong admin", handler, request(http.MethodPost, "/api/refresh", true, false), http.StatusUnauthorized},
+		{"admin disabled", withoutAdmin, request(http.MethodPost, "/api/refresh", false, false), http.StatusForbidden},
+		{"unknown path", handler, request(http.MethodGet, "/nowhere", true, false), http.StatusNotFound},
+		{"unknown player", handler, request(http.MethodGet, "/api/players/Nobody", true, false), http.StatusNotFound},
+		{"wrong method", handler, request(http.MethodDelete, "/api", true, false), http.StatusMethodNotAllowed},
+		{"refresh while logged out", handler, request(http.MethodPost, "/api/refresh", true, true), http.StatusConflict},
+	} {
+		checkErrorBody(t, tc.name, serve(tc.handler, tc.request), tc.status)
+	}
+
+	// The burst was used up by the request above.
+	checkErrorBody(t, "rate limited", serve(withoutAdmin, httptest.NewRequest(http.MethodGet, "/api", nil)), http.StatusTooManyRequests)
+}
+
+func TestWriteInternalError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	// A handler which fails after setting up its headers.
+	recorder.Header().Set("Content-Length", "1234")
+	writeInternalError(recorder)
+	checkErrorBody(t, "internal error", recorder, http.StatusInternalServerError)
+	if got := recorder.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length %s left over", got)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusNotFound:            "not_found",
+		http.StatusServiceUnavailable:  "unavailable",
+		http.StatusInternalServerError: "internal_error",
+		http.StatusTeapot:              "http_418",
+	} {
+		if got := errorCode(status); got != want {
+			t.Errorf("errorCode(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	sseWriteTimeout      = 10 * time.Second
+)
+
+func (s *ServerState) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	clearDeadlines(w)
+	controller := http.NewResponseController(w)
+
+	updates := s.broadcaster.Subscribe()
+	defer s.broadcaster.Unsubscribe(updates)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	event := "snapshot"
+	for {
+		var message string
+		select {
+		case snapshot := <-updates:
+			message = fmt.Sprintf("event: %s\ndata: %s\n\n", event, snapshot)
+			event = "update"
+		case <-heartbeat.C:
+			message = ": heartbeat\n\n"
+		case <-r.Context().Done():
+			return
+		}
+		// Each message gets its own deadline, so a client which stopped
+		// reading is still dropped.
+		setDeadline(controller.SetWriteDeadline(time.Now().Add(sseWriteTimeout)))
+		fmt.Fprint(w, message)
+		flusher.Flush()
+	}
+}
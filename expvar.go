@@ -0,0 +1,71 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// ExpvarStats is published as the tinymush variable at /debug/vars. It is
+// read from Metrics, so it always agrees with /metrics.
+type ExpvarStats struct {
+	MetricsSnapshot
+	PlayersOnline         int    `json:"playersOnline"`
+	State                 string `json:"state"`
+	LocationCacheSize     int    `json:"locationCacheSize"`
+	UnknownLocationsQueue int    `json:"unknownLocationsQueue"`
+}
+
+func (s *ServerState) expvarStats() any {
+	stats := ExpvarStats{
+		MetricsSnapshot: s.metrics.Snapshot(),
+		PlayersOnline:   s.metrics.Players(),
+		State:           s.metrics.State(),
+	}
+	stats.LocationCacheSize, stats.UnknownLocationsQueue = s.locations.Size()
+	return stats
+}
+
+// publishExpvars registers the stats with expvar, which only allows each
+// name to be published once per process.
+func (s *ServerState) publishExpvars() {
+	if expvar.Get("tinymush") == nil {
+		expvar.Publish("tinymush", expvar.Func(s.expvarStats))
+	}
+}
+
+// serveExpvars writes all expvar variables like expvar.Handler, except for
+// cmdline, which would give away the connect command and its password.
+func serveExpvars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
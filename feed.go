@@ -0,0 +1,216 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlayerEvent is a player connecting to or disconnecting from the MUSH, as
+// seen by comparing successive WHO results.
+type PlayerEvent struct {
+	Time      time.Time
+	Player    string
+	Connected bool
+	Location  string
+}
+
+func (e PlayerEvent) Title() string {
+	if e.Connected {
+		return fmt.Sprintf("%s connected", e.Player)
+	}
+	return fmt.Sprintf("%s disconnected from %s", e.Player, e.Location)
+}
+
+// EventLog keeps the most recent player events, dropping the oldest ones
+// once it is full.
+type EventLog struct {
+	mu     sync.RWMutex
+	events []PlayerEvent
+	size   int
+}
+
+func NewEventLog(size int) *EventLog {
+	return &EventLog{
+		events: make([]PlayerEvent, 0, size),
+		size:   size,
+	}
+}
+
+func (l *EventLog) Add(events ...PlayerEvent) {
+	if l.size <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, events...)
+	if len(l.events) > l.size {
+		l.events = append(l.events[:0:0], l.events[len(l.events)-l.size:]...)
+	}
+}
+
+// Recent returns the retained events, newest first.
+func (l *EventLog) Recent() []PlayerEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	recent := make([]PlayerEvent, len(l.events))
+	for i, event := range l.events {
+		recent[len(l.events)-1-i] = event
+	}
+	return recent
+}
+
+// diffPlayers returns the events which turn the old player list into the
+// new one.
+func diffPlayers(old []*MushPlayer, new []*MushPlayer, now time.Time) []PlayerEvent {
+	oldPlayers := make(map[string]*MushPlayer)
+	for _, player := range old {
+		if player != nil {
+			oldPlayers[player.Name] = player
+		}
+	}
+	events := make([]PlayerEvent, 0)
+	for _, player := range new {
+		if player == nil {
+			continue
+		}
+		if _, ok := oldPlayers[player.Name]; ok {
+			delete(oldPlayers, player.Name)
+			continue
+		}
+		events = append(events, PlayerEvent{
+			Time:      now,
+			Player:    player.Name,
+			Connected: true,
+			Location:  player.LocationName(),
+		})
+	}
+	for _, player := range old {
+		if player == nil {
+			continue
+		}
+		if _, ok := oldPlayers[player.Name]; ok {
+			events = append(events, PlayerEvent{
+				Time:     now,
+				Player:   player.Name,
+				Location: player.LocationName(),
+			})
+		}
+	}
+	return events
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Content atomContent `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// feedTagDate is the date in the tag URI of a feed without --public-url.
+// It never changes, so neither does the feed's id.
+var feedTagDate = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// tagURI builds a tag URI (RFC 4151), which stays the same for an event no
+// matter how often or from where the feed is fetched.
+func tagURI(authority string, date time.Time, specific string) string {
+	return fmt.Sprintf("tag:%s,%s:%s", authority, date.UTC().Format(time.DateOnly), specific)
+}
+
+// serveFeed serves the Atom feed. Its id has to stay the same wherever it
+// is fetched from, so it comes from --public-url, or else is a tag URI of
+// the MUSH host. Only the self link falls back to the requested host.
+func (s *ServerState) serveFeed(w http.ResponseWriter, r *http.Request) {
+	authority := s.config.TelnetHost
+	if host, err := url.Parse("telnet://" + authority); err == nil && host.Hostname() != "" {
+		authority = host.Hostname()
+	}
+	var id, self string
+	if s.config.PublicURL != "" {
+		id = s.config.PublicURL + r.URL.Path
+		self = id
+	} else {
+		id = tagURI(authority, feedTagDate, strings.TrimPrefix(r.URL.Path, "/"))
+		link := url.URL{Scheme: "http", Host: r.Host, Path: s.config.BasePath + r.URL.Path}
+		if r.TLS != nil {
+			link.Scheme = "https"
+		}
+		self = link.String()
+	}
+
+	events := s.events.Recent()
+	updated := s.startedAt
+	if len(events) > 0 {
+		updated = events[0].Time
+	}
+	feed := atomFeed{
+		ID:      id,
+		Title:   fmt.Sprintf("%s connections", s.config.TelnetHost),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  "tinymush_status_server",
+		Link:    atomLink{Rel: "self", Href: self},
+		Entries: make([]atomEntry, 0, len(events)),
+	}
+	for _, event := range events {
+		kind := "disconnected"
+		if event.Connected {
+			kind = "connected"
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      tagURI(authority, event.Time, fmt.Sprintf("%s/%s/%d", kind, url.PathEscape(event.Player), event.Time.UnixNano())),
+			Title:   event.Title(),
+			Updated: event.Time.UTC().Format(time.RFC3339),
+			Content: atomContent{Type: "text", Text: event.Title()},
+		})
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,70 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeedID(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		id   string
+		self string
+	}{
+		{nil, "tag:mush.example,2024-01-01:feed.atom", "http://%s/feed.atom"},
+		{[]string{"--base-path", "/mush"}, "tag:mush.example,2024-01-01:feed.atom", "http://%s/mush/feed.atom"},
+		{[]string{"--public-url", "https://example.org/mush"}, "https://example.org/mush/feed.atom", "https://example.org/mush/feed.atom"},
+	} {
+		s := newTestState(t, append([]string{"--host", "mush.example:4201"}, tc.args...)...)
+		handler := s.handler(s.routes())
+		// The id is the same however the feed is reached.
+		for _, host := range []string{"localhost:8080", "status.example.org"} {
+			request := httptest.NewRequest(http.MethodGet, s.config.BasePath+"/feed.atom", nil)
+			request.Host = host
+			response := serve(handler, request)
+			if response.Code != http.StatusOK {
+				t.Fatalf("%v: %s answered %d", tc.args, request.URL, response.Code)
+			}
+			var feed atomFeed
+			if err := xml.Unmarshal(response.Body.Bytes(), &feed); err != nil {
+				t.Fatal(err)
+			}
+			if feed.ID != tc.id {
+				t.Errorf("%v, %s: id is %s, want %s", tc.args, host, feed.ID, tc.id)
+			}
+			if self := strings.Replace(tc.self, "%s", host, 1); feed.Link.Href != self {
+				t.Errorf("%v, %s: self link is %s, want %s", tc.args, host, feed.Link.Href, self)
+			}
+		}
+	}
+}
+
+func TestInvalidPublicURL(t *testing.T) {
+	for _, public := range []string{"example.org/mush", "ftp://example.org", "https://"} {
+		err := runServer(testConfig(t, "--public-url", public), context.Background(), serverEnv{})
+		if err == nil || !strings.Contains(err.Error(), "--public-url") {
+			t.Errorf("--public-url %s: %v", public, err)
+		}
+	}
+}
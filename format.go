@@ -0,0 +1,171 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// negotiateContentType picks the offer the Accept header prefers. Ties go to
+// the earlier offer, and the first offer is the default when the header is
+// missing or matches nothing.
+func negotiateContentType(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q value the Accept header assigns to mediaType,
+// taking the most specific matching media range.
+func acceptQuality(accept string, mediaType string) float64 {
+	offerType, offerSubtype, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, _ := strings.Cut(rangeType, "/")
+		var s int
+		switch {
+		case typ == offerType && subtype == offerSubtype:
+			s = 2
+		case typ == offerType && subtype == "*":
+			s = 1
+		case typ == "*" && subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity = s
+		q = 1
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// wideRanges are the East Asian wide and fullwidth characters, and the
+// emoji, which take two columns in a terminal.
+var wideRanges = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x1100, Hi: 0x115f, Stride: 1},
+		{Lo: 0x2e80, Hi: 0x303e, Stride: 1},
+		{Lo: 0x3040, Hi: 0xa4cf, Stride: 1},
+		{Lo: 0xac00, Hi: 0xd7a3, Stride: 1},
+		{Lo: 0xf900, Hi: 0xfaff, Stride: 1},
+		{Lo: 0xfe30, Hi: 0xfe4f, Stride: 1},
+		{Lo: 0xff00, Hi: 0xff60, Stride: 1},
+		{Lo: 0xffe0, Hi: 0xffe6, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f300, Hi: 0x1f64f, Stride: 1},
+		{Lo: 0x1f900, Hi: 0x1f9ff, Stride: 1},
+		{Lo: 0x20000, Hi: 0x3fffd, Stride: 1},
+	},
+}
+
+// displayWidth returns the number of columns s takes in a terminal:
+// combining marks and other invisible characters take none, wide ones two.
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		switch {
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc):
+		case unicode.Is(wideRanges, r):
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// padRight fills s with spaces up to width columns.
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(width-displayWidth(s), 0))
+}
+
+// formatPlayerTable renders the players as a plain text table, one player
+// per line, with a trailing count. Columns are as wide as their longest
+// field on screen, not in bytes, so names in other scripts line up too.
+func formatPlayerTable(players []*MushPlayer) []byte {
+	width := len("Name")
+	locationWidth := len("Location")
+	count := 0
+	for _, player := range players {
+		if player == nil {
+			continue
+		}
+		count++
+		width = max(width, displayWidth(player.Name))
+		locationWidth = max(locationWidth, displayWidth(player.LocationName()))
+	}
+
+	var buffer bytes.Buffer
+	row := func(name string, location string, doing string) {
+		line := padRight(name, width) + "  " + padRight(location, locationWidth) + "  " + doing
+		buffer.WriteString(strings.TrimRight(line, " "))
+		buffer.WriteByte('\n')
+	}
+	row("Name", "Location", "Doing")
+	for _, player := range players {
+		if player == nil {
+			continue
+		}
+		row(player.Name, player.LocationName(), player.Doing)
+	}
+	if count == 1 {
+		fmt.Fprintln(&buffer, "1 player connected.")
+	} else {
+		fmt.Fprintf(&buffer, "%d players connected.\n", count)
+	}
+	return buffer.Bytes()
+}
+
+func formatXML(status any, pretty bool) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buffer)
+	if pretty {
+		encoder.Indent("", "  ")
+	}
+	err := encoder.EncodeElement(status, xml.StartElement{Name: xml.Name{Local: "status"}})
+	buffer.WriteByte('\n')
+	return buffer.Bytes(), err
+}
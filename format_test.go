@@ -0,0 +1,198 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+	}{
+		{"Walker", 6},
+		{"Zoë", 3},
+		{"Zoe\u0308", 3},
+		{"李雷", 4},
+		{"ＡＢ", 4},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if got := displayWidth(test.text); got != test.width {
+			t.Errorf("displayWidth(%q) = %d, want %d", test.text, got, test.width)
+		}
+	}
+}
+
+func TestPlayerTableColumns(t *testing.T) {
+	latin1, err := NewCharset("latin1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp437, err := NewCharset("cp437")
+	if err != nil {
+		t.Fatal(err)
+	}
+	players := []*MushPlayer{
+		{Name: "Walker", Location: "Town Square", Doing: "Fishing"},
+		{Name: latin1.Decode("J\xf8rgen"), Location: MushLocation(latin1.Decode("\xc6r\xf8sk\xf8bing")), Doing: "Zoë's café"},
+		{Name: "李雷", Location: "天坛", Doing: "散步"},
+		{Name: cp437.Decode("Ren\x82e"), Location: "The Docks"},
+	}
+	table := string(formatPlayerTable(players))
+	lines := strings.Split(strings.TrimSuffix(table, "\n"), "\n")
+	if len(lines) != len(players)+2 {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(players)+2, table)
+	}
+	if lines[3] != "李雷    天坛         散步" {
+		t.Errorf("wide row = %q", lines[3])
+	}
+	if lines[4] != "Renée   The Docks" {
+		t.Errorf("cp437 row = %q", lines[4])
+	}
+
+	// Every column starts at the same place on screen.
+	header := lines[0]
+	locationColumn := strings.Index(header, "Location")
+	doingColumn := strings.Index(header, "Doing")
+	for i, player := range players {
+		line := lines[i+1]
+		location := strings.Index(line, player.LocationName())
+		if got := displayWidth(line[:location]); got != locationColumn {
+			t.Errorf("location of %s at column %d, want %d", player.Name, got, locationColumn)
+		}
+		if player.Doing == "" {
+			continue
+		}
+		doing := strings.LastIndex(line, player.Doing)
+		if got := displayWidth(line[:doing]); got != doingColumn {
+			t.Errorf("doing of %s at column %d, want %d", player.Name, got, doingColumn)
+		}
+	}
+}
+
+// TestXMLMatchesJSON decodes the XML and the JSON response of the same state
+// into the same schema and compares them.
+func TestXMLMatchesJSON(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	s.locations.Set("#10", "Town <Square> & Co")
+	setPlayers(s,
+		&MushPlayer{Name: "Walker", Location: "#10", Doing: "Fishing \"here\""},
+		&MushPlayer{Name: "Zoë", Location: "#11"},
+		&MushPlayer{Name: "Guest"},
+	)
+	handler := s.handler(s.routes())
+	get := func(path string, accept string) []byte {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept", accept)
+		response := serve(handler, request)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s as %s answered %d", path, accept, response.Code)
+		}
+		return response.Body.Bytes()
+	}
+
+	for _, tc := range []struct {
+		jsonPath string
+		xmlPath  string
+		decoded  func() any
+	}{
+		{"/api", "/api.xml", func() any { return &StatusV1{} }},
+		{"/api?pretty=1", "/api.xml?pretty=1", func() any { return &StatusV1{} }},
+		{"/api?raw=1", "/api.xml?raw=1", func() any { return &StatusV1{} }},
+		{"/api/v2", "/api/v2", func() any { return &StatusV2{} }},
+	} {
+		fromJSON, fromXML := tc.decoded(), tc.decoded()
+		if err := json.Unmarshal(get(tc.jsonPath, "application/json"), fromJSON); err != nil {
+			t.Fatalf("%s: %v", tc.jsonPath, err)
+		}
+		if err := xml.Unmarshal(get(tc.xmlPath, "application/xml"), fromXML); err != nil {
+			t.Fatalf("%s: %v", tc.xmlPath, err)
+		}
+		if players := reflect.ValueOf(fromXML).Elem().FieldByName("Players").Len(); players != 3 {
+			t.Errorf("%s: %d players", tc.xmlPath, players)
+		}
+		if !reflect.DeepEqual(fromJSON, fromXML) {
+			t.Errorf("%s and %s differ:\n%+v\n%+v", tc.jsonPath, tc.xmlPath, fromJSON, fromXML)
+		}
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "text/plain", "application/xml"}
+	for _, tc := range []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/plain", "text/plain"},
+		{"application/xml", "application/xml"},
+		{"text/*", "text/plain"},
+		{"text/html", "application/json"},
+		{"garbage;;", "application/json"},
+		// The highest q value wins, wherever it is in the header.
+		{"application/json;q=0.5, text/plain", "text/plain"},
+		{"text/plain;q=0.2, application/xml;q=0.9, application/json;q=0.4", "application/xml"},
+		{"text/plain;q=0.8, */*;q=0.1", "text/plain"},
+		// The most specific range counts, even if a wider one has more.
+		{"*/*, application/json;q=0", "text/plain"},
+		// On a tie, the earlier offer does.
+		{"application/xml, text/plain", "text/plain"},
+		{"application/xml;q=0.5, application/json;q=0.5", "application/json"},
+		// Browsers.
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/xml"},
+	} {
+		if got := negotiateContentType(tc.accept, offers...); got != tc.want {
+			t.Errorf("Accept %q: %s, want %s", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestAPITextGolden(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	s.locations.Set("#10", "Town Square")
+	setPlayers(s,
+		&MushPlayer{Name: "Walker", Location: "#10", Doing: "Fishing"},
+		&MushPlayer{Name: "Zoë", Location: "#11"},
+		&MushPlayer{Name: "Guest"},
+	)
+	handler := s.handler(s.routes())
+	for _, accept := range []string{"text/plain", "text/plain, application/json;q=0.5"} {
+		request := httptest.NewRequest(http.MethodGet, "/api", nil)
+		request.Header.Set("Accept", accept)
+		response := serve(handler, request)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Accept %s answered %d", accept, response.Code)
+		}
+		if contentType := response.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+			t.Errorf("Accept %s got %s", accept, contentType)
+		}
+		checkGolden(t, "api.golden.txt", response.Body.Bytes())
+	}
+}
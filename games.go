@@ -0,0 +1,137 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// NamedValues holds a repeatable flag of name:value pairs.
+type NamedValues map[string]string
+
+// gameNamePattern keeps game names usable as a single path segment.
+var gameNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedGameNames are taken by the other routes below /api/.
+var reservedGameNames = []string{"v1", "v2", "players", "locations", "diff", "rooms", "history", "stats", "events", "badge", "refresh", "cache", "reconnect"}
+
+// GamesStatus is the /api response when several games are monitored. It
+// holds the v2 response of every game by name.
+type GamesStatus struct {
+	Games map[string]StatusV2 `json:"games"`
+}
+
+// newGames sets up a state machine for the --host game and one for each
+// --game, each with its own connection, location cache and stats. The
+// --host game comes first and serves HTTP for all of them. Without --game
+// it is the only one and has no name.
+func newGames(config ServerConfig) ([]*ServerState, error) {
+	if err := checkHostPort(config.TelnetHost); err != nil {
+		return nil, fmt.Errorf("invalid --host: %w", err)
+	}
+	if len(config.Games) == 0 {
+		s, err := newServerState(config, "")
+		if err != nil {
+			return nil, err
+		}
+		return []*ServerState{s}, nil
+	}
+	for name := range config.GameConnect {
+		if _, ok := config.Games[name]; !ok {
+			return nil, fmt.Errorf("--game-connect given for unknown game %s", name)
+		}
+	}
+	names := make([]string, 0, len(config.Games))
+	for name := range config.Games {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = append([]string{config.GameName}, names...)
+	for i, name := range names {
+		if !gameNamePattern.MatchString(name) || slices.Contains(reservedGameNames, name) {
+			return nil, fmt.Errorf("invalid game name %s", name)
+		}
+		if slices.Contains(names[:i], name) {
+			return nil, fmt.Errorf("game %s is given twice, use --game-name to rename the --host game", name)
+		}
+		if i > 0 {
+			if err := checkHostPort(config.Games[name]); err != nil {
+				return nil, fmt.Errorf("invalid --game %s: %w", name, err)
+			}
+		}
+	}
+
+	games := make([]*ServerState, 0, len(names))
+	for i, name := range names {
+		gameConfig := config
+		if i > 0 {
+			gameConfig.TelnetHost = config.Games[name]
+			if command, ok := config.GameConnect[name]; ok {
+				gameConfig.ConnectCmd = command
+				gameConfig.LoginSteps = nil
+			}
+		}
+		game, err := newServerState(gameConfig, name)
+		if err != nil {
+			return nil, fmt.Errorf("game %s: %w", name, err)
+		}
+		games = append(games, game)
+	}
+	games[0].games = games
+	return games, nil
+}
+
+// serveGames serves the default v2 response of every game. Each game has its
+// own stale flag, so the response is always a 200.
+func (s *ServerState) serveGames(w http.ResponseWriter, r *http.Request) {
+	// The cached bodies are embedded as they are rather than decoded into
+	// GamesStatus and encoded again.
+	response := struct {
+		Games map[string]json.RawMessage `json:"games"`
+	}{
+		Games: make(map[string]json.RawMessage, len(s.games)),
+	}
+	for _, game := range s.games {
+		rendered := game.cachedStatus(API_V2)
+		if rendered == nil {
+			var err error
+			game.mu.RLock()
+			rendered, err = game.renderStatus(API_V2, defaultStatusRequest)
+			game.mu.RUnlock()
+			if err != nil {
+				s.logRequest(r, slog.LevelError, "Failed to encode status of %s: %v", game.name, err)
+				writeInternalError(w)
+				return
+			}
+		}
+		response.Games[game.name] = rendered.Body
+	}
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
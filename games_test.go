@@ -0,0 +1,179 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// mushDialer dials the fake MUSH of each --host or --game address.
+type mushDialer map[string]*fakeMUSH
+
+func (d mushDialer) Dial(network string, address string) (net.Conn, error) {
+	return d[address].Dial(network, address)
+}
+
+// startGames runs a server for the main game and one called other, whose
+// WHO is who_other.txt.
+func startGames(t *testing.T, args ...string) (*testServer, *fakeMUSH, *fakeMUSH) {
+	t.Helper()
+	main, other := newFakeMUSH(t), newFakeMUSH(t)
+	other.SetWho("who_other.txt")
+	other.rooms["#20"] = "The Tower"
+	dialer := mushDialer{"main.example:4201": main, "other.example:4201": other}
+	args = append([]string{"--host", "main.example:4201", "--game", "other:other.example:4201"}, args...)
+	ts := startServer(t, main, serverEnv{dialer: dialer}, args...)
+	waitFor(t, "both games", func() bool {
+		var status GamesStatus
+		ts.get("/api", &status)
+		return len(status.Games["main"].Players) == 3 && len(status.Games["other"].Players) == 1 &&
+			status.Games["other"].Players[0].Location == "The Tower"
+	})
+	return ts, main, other
+}
+
+func (ts *testServer) getText(path string) (int, string) {
+	ts.t.Helper()
+	response, err := http.Get(ts.url + path)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return response.StatusCode, string(body)
+}
+
+func TestGamesMetrics(t *testing.T) {
+	ts, _, _ := startGames(t)
+	_, metrics := ts.getText("/metrics")
+	for _, want := range []string{
+		"tinymush_players{game=\"main\"} 3\n",
+		"tinymush_players{game=\"other\"} 1\n",
+		"tinymush_location_players{game=\"other\",location=\"The Tower\"} 1\n",
+		"tinymush_telnet_state{game=\"other\",state=\"idle\"} 1\n",
+		"tinymush_who_polls_succeeded_total{game=\"main\"} ",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("no %q in the metrics", want)
+		}
+	}
+	if n := strings.Count(metrics, "# TYPE tinymush_players "); n != 1 {
+		t.Errorf("tinymush_players has %d TYPE lines, want one for all games", n)
+	}
+}
+
+func TestGamesHealth(t *testing.T) {
+	ts, _, other := startGames(t)
+	var health HealthStatus
+	if code := ts.get("/healthz", &health); code != http.StatusOK || !health.Healthy || len(health.Games) != 2 {
+		t.Fatalf("healthz answered %d %+v", code, health)
+	}
+	var ready ReadyStatus
+	if code := ts.get("/readyz", &ready); code != http.StatusOK || !ready.Ready || len(ready.Games) != 2 {
+		t.Fatalf("readyz answered %d %+v", code, ready)
+	}
+
+	// The other game going down makes the server unhealthy, although the
+	// main game is fine.
+	other.Close()
+	waitFor(t, "healthz to notice", func() bool {
+		health = HealthStatus{}
+		return ts.get("/healthz", &health) == http.StatusServiceUnavailable
+	})
+	if health.Healthy || !health.Games["main"].Healthy || health.Games["other"].Healthy {
+		t.Errorf("healthz has %+v", health)
+	}
+}
+
+func TestGamesEndpoints(t *testing.T) {
+	ts, _, _ := startGames(t, "--debug")
+
+	var rooms []Room
+	if code := ts.get("/api/other/rooms", &rooms); code != http.StatusOK || len(rooms) != 1 || rooms[0].Location != "The Tower" {
+		t.Errorf("/api/other/rooms answered %d %+v", code, rooms)
+	}
+	var diff DiffResponse
+	if code := ts.get("/api/other/diff?since=0", &diff); code != http.StatusOK || len(diff.Players)+len(diff.Added) != 1 {
+		t.Errorf("/api/other/diff answered %d %+v", code, diff)
+	}
+	var state DebugState
+	if code := ts.get("/debug/state/other", &state); code != http.StatusOK || len(state.Messages) == 0 || !strings.Contains(state.Messages[len(state.Messages)-1].Text, "The Tower") {
+		t.Errorf("/debug/state/other answered %d %+v", code, state)
+	}
+	if code, feed := ts.getText("/api/other/feed.atom"); code != http.StatusOK || !strings.Contains(feed, "other.example") {
+		t.Errorf("/api/other/feed.atom answered %d %s", code, feed)
+	}
+
+	conn := dialWebSocket(t, ts.url+"/api/other/ws")
+	if status := readSnapshot(t, conn); len(status.Players) != 1 || status.Players[0].Name != "Mira" {
+		t.Errorf("/api/other/ws sent %+v", status)
+	}
+}
+
+func TestGamesAdminEndpoints(t *testing.T) {
+	ts, main, other := startGames(t, "--admin-user", "admin", "--admin-pass", "pass")
+	post := func(path string) int {
+		t.Helper()
+		request, err := http.NewRequest(http.MethodPost, ts.url+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.SetBasicAuth("admin", "pass")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	var player PlayerV1
+	if code := ts.get("/api/other/players/mira", &player); code != http.StatusOK || player.Location != "The Tower" {
+		t.Errorf("/api/other/players/mira answered %d %+v", code, player)
+	}
+	var locations LocationsResponse
+	if code := ts.get("/api/other/locations", &locations); code != http.StatusOK || locations.Locations["#20"] != "The Tower" {
+		t.Errorf("/api/other/locations answered %d %+v", code, locations)
+	}
+	if code, history := ts.getText("/api/other/history"); code != http.StatusOK || !strings.HasPrefix(history, "[") {
+		t.Errorf("/api/other/history answered %d %s", code, history)
+	}
+
+	other.Forget()
+	if code := post("/api/other/refresh"); code != http.StatusAccepted {
+		t.Errorf("/api/other/refresh answered %d", code)
+	}
+	other.Expect("who")
+	if code := post("/api/other/reconnect"); code != http.StatusAccepted {
+		t.Errorf("/api/other/reconnect answered %d", code)
+	}
+	waitFor(t, "the other game to log in again", func() bool {
+		_, logins := other.Counts()
+		return logins == 2
+	})
+	if _, logins := main.Counts(); logins != 1 {
+		t.Errorf("the main game logged in %d times", logins)
+	}
+}
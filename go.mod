@@ -3,9 +3,11 @@ module github.com/HappyTetrahedron/midgaard_bot
 go 1.21.5
 
 require (
+	github.com/gorilla/websocket v1.5.1
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/reiver/go-oi v1.0.0
 	github.com/reiver/go-telnet v0.0.0-20180421082511-9ff0b2ab096e
+	golang.org/x/net v0.17.0
 )
 
 require golang.org/x/sys v0.16.0 // indirect
@@ -0,0 +1,179 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type ReadyStatus struct {
+	Ready             bool       `json:"ready"`
+	LastSuccessfulWho *time.Time `json:"lastSuccessfulWho"`
+	SecondsSinceWho   *float64   `json:"secondsSinceWho"`
+	// Games has the status of every game by name if there are several.
+	// Ready is only set if all of them are.
+	Games map[string]ReadyStatus `json:"games,omitempty"`
+}
+
+type HealthStatus struct {
+	Healthy           bool       `json:"healthy"`
+	State             string     `json:"state"`
+	StateSince        time.Time  `json:"stateSince"`
+	SecondsInState    float64    `json:"secondsInState"`
+	LastSuccessfulWho *time.Time `json:"lastSuccessfulWho"`
+	// LastDialError is why the latest connection attempt failed, if it did.
+	LastDialError *DebugError `json:"lastDialError,omitempty"`
+	// Games has the status of every game by name if there are several.
+	// Healthy is only set if all of them are, the rest is about the
+	// --host game.
+	Games map[string]HealthStatus `json:"games,omitempty"`
+}
+
+// isLoggedIn reports whether the state machine is past the login and
+// polling the MUSH.
+func isLoggedIn(state string) bool {
+	switch state {
+	case STATE_IDLE, STATE_AWAIT_WHO, STATE_AWAIT_LOC, STATE_KEEPALIVE, STATE_SETUP, STATE_PROBE:
+		return true
+	}
+	return false
+}
+
+// connectionName maps a state to what clients need to know: disconnected,
+// connecting, logging_in, login_failed or connected.
+func connectionName(state string) string {
+	switch {
+	case isLoggedIn(state):
+		return "connected"
+	case state == STATE_CONNECTING:
+		return "connecting"
+	case state == STATE_LOGGING_IN:
+		return "logging_in"
+	case state == STATE_LOGIN_FAILED:
+		return "login_failed"
+	}
+	return "disconnected"
+}
+
+// healthStatus returns the health of every game.
+func (s *ServerState) healthStatus() HealthStatus {
+	status := s.gameHealth()
+	if s.games == nil {
+		return status
+	}
+	status.Games = make(map[string]HealthStatus, len(s.games))
+	for _, game := range s.games {
+		health := game.gameHealth()
+		status.Games[game.name] = health
+		status.Healthy = status.Healthy && health.Healthy
+	}
+	return status
+}
+
+func (s *ServerState) gameHealth() HealthStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := HealthStatus{
+		Healthy:        isLoggedIn(s.currentState),
+		State:          s.currentState,
+		StateSince:     s.stateSince,
+		SecondsInState: s.now().Sub(s.stateSince).Seconds(),
+		LastDialError:  s.dialError,
+	}
+	if !s.lastSuccessfulWho.IsZero() {
+		lastWho := s.lastSuccessfulWho
+		status.LastSuccessfulWho = &lastWho
+	}
+	return status
+}
+
+func (s *ServerState) serveHealth(w http.ResponseWriter, r *http.Request) {
+	status := s.healthStatus()
+	jsonBody, err := json.Marshal(status)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(jsonBody)
+}
+
+// isStale reports whether the player list can't be trusted, because the
+// server isn't logged in or hasn't seen a WHO for staleAfter.
+func isStale(state string, lastWho time.Time, staleAfter time.Duration, now time.Time) bool {
+	if !isLoggedIn(state) || lastWho.IsZero() {
+		return true
+	}
+	return now.Sub(lastWho) > staleAfter
+}
+
+// isStale reports whether the current player list is stale. The caller
+// holds s.mu.
+func (s *ServerState) isStale() bool {
+	return isStale(s.currentState, s.lastSuccessfulWho, s.config.StaleAfter, s.now())
+}
+
+// readyStatus returns the readiness of every game.
+func (s *ServerState) readyStatus() ReadyStatus {
+	status := s.gameReady()
+	if s.games == nil {
+		return status
+	}
+	status.Games = make(map[string]ReadyStatus, len(s.games))
+	for _, game := range s.games {
+		ready := game.gameReady()
+		status.Games[game.name] = ready
+		status.Ready = status.Ready && ready.Ready
+	}
+	return status
+}
+
+func (s *ServerState) gameReady() ReadyStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := ReadyStatus{}
+	if s.lastSuccessfulWho.IsZero() {
+		return status
+	}
+	lastWho := s.lastSuccessfulWho
+	age := s.now().Sub(lastWho)
+	seconds := age.Seconds()
+	status.LastSuccessfulWho = &lastWho
+	status.SecondsSinceWho = &seconds
+	status.Ready = age <= s.config.StaleAfter
+	return status
+}
+
+func (s *ServerState) serveReady(w http.ResponseWriter, r *http.Request) {
+	status := s.readyStatus()
+	jsonBody, err := json.Marshal(status)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(jsonBody)
+}
@@ -0,0 +1,168 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type HistorySample struct {
+	Time      time.Time      `json:"time"`
+	Players   int            `json:"players"`
+	Locations map[string]int `json:"locations,omitempty"`
+}
+
+// HistoryPoint is a bucket of samples, downsampled to the average and
+// maximum player count within it.
+type HistoryPoint struct {
+	Time    time.Time `json:"time"`
+	Average float64   `json:"average"`
+	Max     int       `json:"max"`
+	Samples int       `json:"samples"`
+}
+
+// History is a ring buffer of player counts, bounded both in number of
+// samples and in age.
+type History struct {
+	mu        sync.RWMutex
+	samples   []HistorySample
+	start     int
+	size      int
+	retention time.Duration
+}
+
+func NewHistory(size int, retention time.Duration) *History {
+	size = max(size, 1)
+	return &History{
+		samples:   make([]HistorySample, 0, size),
+		size:      size,
+		retention: retention,
+	}
+}
+
+func (h *History) Record(now time.Time, players []*MushPlayer) {
+	sample := HistorySample{Time: now, Locations: make(map[string]int)}
+	for _, player := range players {
+		if player == nil {
+			continue
+		}
+		sample.Players++
+		sample.Locations[player.LocationName()]++
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// The ring only starts wrapping around once it is full.
+	if len(h.samples) < h.size {
+		h.samples = append(h.samples, sample)
+	} else {
+		h.samples[h.start] = sample
+		h.start = (h.start + 1) % len(h.samples)
+	}
+	h.evict(now)
+}
+
+// evict drops samples older than the retention. The caller must hold h.mu.
+func (h *History) evict(now time.Time) {
+	if h.retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-h.retention)
+	if len(h.samples) == 0 || !h.samples[h.start].Time.Before(cutoff) {
+		return
+	}
+	ordered := h.ordered()
+	keep := 0
+	for keep < len(ordered) && ordered[keep].Time.Before(cutoff) {
+		keep++
+	}
+	h.samples = append(make([]HistorySample, 0, h.size), ordered[keep:]...)
+	h.start = 0
+}
+
+// ordered returns the samples oldest first. The caller must hold h.mu.
+func (h *History) ordered() []HistorySample {
+	return append(append(make([]HistorySample, 0, len(h.samples)), h.samples[h.start:]...), h.samples[:h.start]...)
+}
+
+// Since returns the samples newer than since, oldest first.
+func (h *History) Since(since time.Time) []HistorySample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	samples := make([]HistorySample, 0, len(h.samples))
+	for _, sample := range h.ordered() {
+		if !sample.Time.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// downsample groups the samples into buckets of the given resolution.
+func downsample(samples []HistorySample, resolution time.Duration) []HistoryPoint {
+	points := make([]HistoryPoint, 0)
+	total := 0
+	for _, sample := range samples {
+		bucket := sample.Time.Truncate(resolution)
+		if len(points) == 0 || !points[len(points)-1].Time.Equal(bucket) {
+			total = 0
+			points = append(points, HistoryPoint{Time: bucket})
+		}
+		point := &points[len(points)-1]
+		total += sample.Players
+		point.Samples++
+		point.Max = max(point.Max, sample.Players)
+		point.Average = float64(total) / float64(point.Samples)
+	}
+	return points
+}
+
+func (s *ServerState) serveHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "since must be an RFC3339 time")
+			return
+		}
+		since = parsed
+	}
+	samples := s.history.Since(since)
+
+	var response any = samples
+	if value := query.Get("resolution"); value != "" {
+		resolution, err := time.ParseDuration(value)
+		if err != nil || resolution <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "resolution must be a positive duration")
+			return
+		}
+		response = downsample(samples, resolution)
+	}
+
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
@@ -0,0 +1,48 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "time"
+
+// keepaliveQuiet is how long the state machine treats the keepalive command
+// as in flight. The default command has no output, but one that does must
+// not be taken for the response to the next WHO.
+const keepaliveQuiet = 2 * time.Second
+
+// keepaliveTicks returns the channel on which loopWorker checks whether a
+// keepalive is due, or nil without --keepalive-interval. Checking four
+// times per interval keeps the gap between commands near the interval.
+func (s *ServerState) keepaliveTicks() (<-chan time.Time, func()) {
+	if s.config.Keepalive <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(s.config.Keepalive / 4)
+	return ticker.C, ticker.Stop
+}
+
+// processKeepalive sends the keepalive command if nothing was sent on the
+// connection for --keepalive-interval. Like every command it goes through
+// the queue, so it never comes between a command and its response. The
+// caller holds s.mu.
+func (s *ServerState) processKeepalive() {
+	if s.currentState != STATE_IDLE || len(s.queue) > 0 || s.now().Sub(s.lastSent) < s.config.Keepalive {
+		return
+	}
+	s.logger.Println("Sending keepalive.")
+	s.enqueue(Command{Text: s.config.KeepaliveCmd, Expect: STATE_KEEPALIVE, Timeout: keepaliveQuiet})
+}
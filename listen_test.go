@@ -0,0 +1,132 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staleSocket leaves a socket file behind at path, as a crash would.
+func staleSocket(t *testing.T, path string) {
+	t.Helper()
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.sock")
+	staleSocket(t, path)
+	mush := newFakeMUSH(t)
+	mush.SetWho("who.txt")
+	config := testConfig(t, "--address", unixPrefix+path, "--socket-mode", "0600", "--host", "mush.example:4201", "--shutdown-timeout", "1s")
+
+	ready := make(chan struct{})
+	env := serverEnv{dialer: mush, ready: func(*ServerState, http.Handler) { close(ready) }}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(config, ctx, env)
+	}()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("server exited: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket has mode %v", info.Mode())
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+	response, err := client.Get("http://status/api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("/api/openapi.json answered %d", response.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after shutdown: %v", err)
+	}
+}
+
+func TestListenRefuses(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "status.sock")
+	if err := os.WriteFile(file, []byte("not a socket"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		address string
+		mode    string
+		err     string
+	}{
+		{unixPrefix + file, "0660", "not a socket"},
+		{unixPrefix + filepath.Join(dir, "other.sock"), "rw", "invalid socket mode"},
+	} {
+		listener, err := listen(tc.address, tc.mode)
+		if err == nil {
+			listener.Close()
+			t.Errorf("%s with mode %s: no error", tc.address, tc.mode)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s with mode %s: %v", tc.address, tc.mode, err)
+		}
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "not a socket" {
+		t.Errorf("the file was touched: %q, %v", data, err)
+	}
+}
+
+func TestListenTCP(t *testing.T) {
+	listener, err := listen("127.0.0.1:0", "0660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if listener.Addr().Network() != "tcp" {
+		t.Errorf("listening on %s", listener.Addr().Network())
+	}
+}
@@ -0,0 +1,97 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// telnetInputSize is how many commands may wait for the telnet writer. The
+// queue sends one at a time, so it only fills up if writing is stuck.
+const telnetInputSize = 16
+
+// tcpKeepalive is the interval of the TCP keepalive probes on the
+// connection to the MUSH, or to the proxy.
+const tcpKeepalive = 30 * time.Second
+
+// enableKeepalive turns on TCP keepalive, so the kernel notices a peer
+// which went away without a reset.
+func enableKeepalive(conn net.Conn) {
+	if buffered, ok := conn.(*bufferedConn); ok {
+		conn = buffered.Conn
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(tcpKeepalive)
+	}
+}
+
+// checkSilence drops the connection if the MUSH hasn't sent a single byte
+// for --dead-after poll intervals although we kept sending commands. A host
+// which dropped off the network without a reset leaves the read blocking
+// forever, and without this the state machine would sit idle and serve old
+// data. Reports whether the connection was dropped. The caller holds s.mu.
+func (s *ServerState) checkSilence() bool {
+	if s.config.DeadAfter <= 0 || !s.lastSent.After(s.lastReceived) {
+		return false
+	}
+	silence := s.now().Sub(s.lastReceived)
+	if silence < time.Duration(s.config.DeadAfter)*s.config.PollInterval {
+		return false
+	}
+	message := fmt.Sprintf("nothing received for %s", silence.Round(time.Second))
+	s.logger.Printf("Connection seems dead, %s.", message)
+	s.recordError("connection dead: " + message)
+	s.metrics.RecordConnectionError()
+	s.disconnect()
+	return true
+}
+
+// processStuck drops the connection when a command couldn't even be handed
+// to its writer, which is still blocked on the ones before. Nothing happens
+// if that session is gone already. The caller holds s.mu.
+func (s *ServerState) processStuck(input chan string) {
+	if input != s.sendChannel || s.cancelSession == nil {
+		return
+	}
+	s.logger.Println("Writing to the MUSH is stuck, reconnecting.")
+	s.recordError("connection stuck: commands can't be written")
+	s.metrics.RecordConnectionError()
+	s.disconnect()
+}
+
+// whoFailed records a WHO response which couldn't be parsed. After
+// --who-failure-limit of them in a row the session is likely stuck in some
+// mode of its own, a pager or a prompt, and only a fresh connection gets it
+// out again. The caller holds s.mu.
+func (s *ServerState) whoFailed(reason string) {
+	s.recordError(reason)
+	s.metrics.RecordWhoResult(false)
+	s.whoFailures = append(s.whoFailures, reason)
+	if s.config.WhoFailLimit <= 0 || len(s.whoFailures) < s.config.WhoFailLimit {
+		return
+	}
+	s.logger.Printf("%d WHOs in a row failed (%s), reconnecting.", len(s.whoFailures), strings.Join(s.whoFailures, ", "))
+	s.recordError(fmt.Sprintf("reconnecting after %d failed WHOs", len(s.whoFailures)))
+	s.metrics.RecordConnectionError()
+	s.whoFailures = nil
+	s.disconnect()
+}
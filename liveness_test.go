@@ -0,0 +1,116 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingDialer connects the first session to the fake MUSH through a
+// pipe, which stops reading what the server writes once stall is closed.
+// As net.Pipe doesn't buffer, the server's writer then blocks right away,
+// like on a MUSH whose socket buffers are full.
+type stallingDialer struct {
+	*fakeMUSH
+	stall chan struct{}
+	once  sync.Once
+}
+
+func (d *stallingDialer) Dial(network string, address string) (net.Conn, error) {
+	conn, err := d.fakeMUSH.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	first := false
+	d.once.Do(func() { first = true })
+	if !first {
+		return conn, nil
+	}
+	server, peer := net.Pipe()
+	go func() {
+		io.Copy(peer, conn)
+		peer.Close()
+		conn.Close()
+	}()
+	// Once stalled, the MUSH's side stays open like that of a MUSH which
+	// hangs, rather than ending the session with an EOF. It is closed once
+	// the server dropped the pipe and the fake MUSH kicks everyone.
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			select {
+			case <-d.stall:
+				return
+			default:
+			}
+			n, err := peer.Read(buf)
+			if err != nil {
+				return
+			}
+			conn.Write(buf[:n])
+		}
+	}()
+	return server, nil
+}
+
+func TestStuckWriterReconnects(t *testing.T) {
+	mush := newFakeMUSH(t)
+	dialer := &stallingDialer{fakeMUSH: mush, stall: make(chan struct{})}
+	ts := startServer(t, mush, serverEnv{dialer: dialer})
+	ts.waitForPlayers()
+
+	close(dialer.stall)
+	mush.Forget()
+	// More than the writer and its queue take. None of them may block the
+	// state machine.
+	sent := make(chan struct{})
+	go func() {
+		ts.state.mu.Lock()
+		defer ts.state.mu.Unlock()
+		for i := 0; i < telnetInputSize+3; i++ {
+			ts.state.send("think Still there?")
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send blocked on the stuck writer")
+	}
+
+	var stats Stats
+	if code := ts.get("/api/stats", &stats); code != http.StatusOK {
+		t.Errorf("stats answered %d during the stall", code)
+	}
+	waitFor(t, "a second login", func() bool {
+		dials, logins := mush.Counts()
+		return dials == 2 && logins == 2
+	})
+	mush.Expect("who")
+	ts.waitForPlayers()
+	stats = Stats{}
+	ts.get("/api/stats", &stats)
+	if stats.ConnectionErrors != 1 {
+		t.Errorf("%d connection errors, want 1", stats.ConnectionErrors)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lookupRetryAfter is how long a location whose lookup failed is left alone
+// before it is looked up again.
+const lookupRetryAfter = 15 * time.Minute
+
+// LocationCache maps location dbrefs to their friendly names and tracks the
+// dbrefs which still need to be looked up, as well as the ones whose lookup
+// failed recently. It is read by HTTP handlers while the telnet side
+// updates it, so all access goes through its lock.
+type LocationCache struct {
+	mu      sync.RWMutex
+	names   map[string]string
+	unknown []string
+	failed  map[string]time.Time
+}
+
+func NewLocationCache() *LocationCache {
+	return &LocationCache{
+		names:   make(map[string]string),
+		unknown: make([]string, 0),
+		failed:  make(map[string]time.Time),
+	}
+}
+
+func (c *LocationCache) Lookup(dbref string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	name, ok := c.names[dbref]
+	return name, ok
+}
+
+// Set records the name of a location and removes it from the unknown queue.
+func (c *LocationCache) Set(dbref string, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names[dbref] = name
+	delete(c.failed, dbref)
+	c.unknown = slices.DeleteFunc(c.unknown, func(unk string) bool {
+		return unk == dbref
+	})
+}
+
+func (c *LocationCache) SetUnknown(unknown []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unknown = unknown
+}
+
+// Clear forgets all names and the unknown queue, so the next WHO looks up
+// every location again. It returns the number of names dropped.
+func (c *LocationCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cleared := len(c.names)
+	c.names = make(map[string]string)
+	c.unknown = make([]string, 0)
+	c.failed = make(map[string]time.Time)
+	return cleared
+}
+
+// Evict forgets the name of a single location and reports whether it was
+// known.
+func (c *LocationCache) Evict(dbref string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.names[dbref]
+	delete(c.names, dbref)
+	delete(c.failed, dbref)
+	return ok
+}
+
+// Unknown returns a copy of the locations which need to be looked up,
+// leaving out the ones whose lookup failed within lookupRetryAfter.
+func (c *LocationCache) Unknown() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	unknown := make([]string, 0, len(c.unknown))
+	for _, dbref := range c.unknown {
+		if failed, ok := c.failed[dbref]; ok && time.Since(failed) < lookupRetryAfter {
+			continue
+		}
+		unknown = append(unknown, dbref)
+	}
+	return unknown
+}
+
+// Fail records that looking up a location failed, so it isn't looked up
+// again after every WHO.
+func (c *LocationCache) Fail(dbref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed[dbref] = time.Now()
+}
+
+// Failed returns the locations whose lookup failed within
+// lookupRetryAfter, sorted.
+func (c *LocationCache) Failed() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	failed := make([]string, 0, len(c.failed))
+	for dbref, at := range c.failed {
+		if time.Since(at) < lookupRetryAfter {
+			failed = append(failed, dbref)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// Size returns the number of known locations and the length of the
+// unknown queue.
+func (c *LocationCache) Size() (int, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.names), len(c.unknown)
+}
+
+// Snapshot returns copies of the known names and the unknown queue.
+func (c *LocationCache) Snapshot() (map[string]string, []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make(map[string]string, len(c.names))
+	for dbref, name := range c.names {
+		names[dbref] = name
+	}
+	return names, slices.Clone(c.unknown)
+}
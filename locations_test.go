@@ -0,0 +1,93 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestManyUnknownRooms resolves every room of a WHO right after it, one
+// lookup at a time, without waiting for the next poll.
+func TestManyUnknownRooms(t *testing.T) {
+	mush := newFakeMUSH(t)
+	mush.SetWho("who_many.txt")
+	for i := 0; i < 12; i++ {
+		mush.rooms[fmt.Sprintf("#%d", 100+i)] = fmt.Sprintf("Room %d", i)
+	}
+	// The first lookup waits, so the queue can be seen draining.
+	release := make(chan struct{})
+	mush.Respond(func(line string) (string, bool) {
+		if strings.Contains(line, "#100") {
+			mush.mu.Unlock()
+			<-release
+			mush.mu.Lock()
+		}
+		return "", false
+	})
+	ts := startServer(t, mush, serverEnv{}, "--poll-interval", "1h")
+
+	// The rest stay queued while the first is in flight.
+	waitFor(t, "the queued lookups", func() bool {
+		var stats Stats
+		ts.get("/api/stats", &stats)
+		return stats.UnknownLocationsQueue == 12 && stats.PendingLookups == 11
+	})
+	close(release)
+
+	var status StatusV1
+	waitFor(t, "every room", func() bool {
+		status = StatusV1{}
+		ts.get("/api/v1", &status)
+		for _, player := range status.Players {
+			if !strings.HasPrefix(player.Location, "Room ") {
+				return false
+			}
+		}
+		return len(status.Players) == 12
+	})
+	var stats Stats
+	ts.get("/api/stats", &stats)
+	if stats.UnknownLocationsQueue != 0 || stats.PendingLookups != 0 || stats.LocationCacheSize != 12 {
+		t.Errorf("after the lookups: %d unknown, %d pending, %d cached", stats.UnknownLocationsQueue, stats.PendingLookups, stats.LocationCacheSize)
+	}
+
+	// A single WHO, and each room looked up once.
+	var sent []string
+	for len(mush.lines) > 0 {
+		sent = append(sent, <-mush.lines)
+	}
+	whos, lookups := 0, map[string]int{}
+	for _, line := range sent {
+		if strings.EqualFold(line, "WHO") {
+			whos++
+		}
+		if match := locationLookup.FindStringSubmatch(line); match != nil {
+			lookups[match[2]]++
+		}
+	}
+	if whos != 1 || len(lookups) != 12 {
+		t.Errorf("sent %d WHOs and looked up %d rooms: %q", whos, len(lookups), sent)
+	}
+	for dbref, count := range lookups {
+		if count != 1 {
+			t.Errorf("looked up %s %d times", dbref, count)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// connectCmdEnv is the environment variable the connect command is taken
+// from if no flag gives it.
+const connectCmdEnv = "CONNECT_CMD"
+
+// LoginStep is one step of logging in: once the MUSH sends something Expect
+// matches, Send is sent. Without Expect, the first message will do.
+type LoginStep struct {
+	Expect *regexp.Regexp
+	Send   string
+}
+
+// compileOptional compiles a pattern given on the command line, where an
+// empty one means none.
+func compileOptional(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// readConnectCmd fills in the connect command from --connect-command-file or
+// CONNECT_CMD, in that order, unless --connect-command gave it already. With
+// --login-step there is no connect command, so the variable is ignored then.
+func readConnectCmd(config *ServerConfig) error {
+	if config.ConnectCmd != "" {
+		return nil
+	}
+	if config.ConnectFile != "" {
+		command, err := os.ReadFile(config.ConnectFile)
+		if err != nil {
+			return fmt.Errorf("invalid --connect-command-file: %w", err)
+		}
+		config.ConnectCmd = strings.TrimRight(string(command), "\r\n")
+		if config.ConnectCmd == "" {
+			return fmt.Errorf("invalid --connect-command-file: %s is empty", config.ConnectFile)
+		}
+		return nil
+	}
+	if len(config.LoginSteps) == 0 {
+		config.ConnectCmd = os.Getenv(connectCmdEnv)
+	}
+	return nil
+}
+
+// guest reports whether the server stays on the connect screen, because
+// there is no connect command to log in with.
+func (s *ServerState) guest() bool {
+	return len(s.loginSteps) == 0
+}
+
+// redactLogin blanks out the connect command wherever it appears in text,
+// and while logging in any other line sent for that, e.g. in the MUSH's echo
+// of it. The caller holds s.mu.
+func (s *ServerState) redactLogin(text string) string {
+	if s.config.ConnectCmd != "" {
+		text = strings.ReplaceAll(text, s.config.ConnectCmd, "[redacted]")
+	}
+	if s.currentState != STATE_LOGGING_IN {
+		return text
+	}
+	for _, step := range s.loginSteps {
+		if step.Send != "" {
+			text = strings.ReplaceAll(text, step.Send, "[redacted]")
+		}
+	}
+	return text
+}
+
+// parseLoginSteps turns the --login-step flags into the login. Without any,
+// the login is to send --connect-command once the connect screen shows, and
+// without that there is no login at all.
+func parseLoginSteps(config ServerConfig) ([]LoginStep, error) {
+	if len(config.LoginSteps) == 0 && config.ConnectCmd == "" {
+		return nil, nil
+	}
+	if len(config.LoginSteps) == 0 {
+		screen, err := compileOptional(config.ConnectScreen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --connect-screen: %w", err)
+		}
+		return []LoginStep{{Expect: screen, Send: config.ConnectCmd}}, nil
+	}
+	if config.ConnectCmd != "" {
+		return nil, errors.New("--login-step can't be combined with a connect command")
+	}
+	steps := make([]LoginStep, 0, len(config.LoginSteps))
+	for i, step := range config.LoginSteps {
+		pattern, line, ok := strings.Cut(step, "=>")
+		if !ok {
+			return nil, fmt.Errorf("login step %d is not PATTERN=>LINE", i+1)
+		}
+		expect, err := compileOptional(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("login step %d: %w", i+1, err)
+		}
+		steps = append(steps, LoginStep{Expect: expect, Send: line})
+	}
+	return steps, nil
+}
+
+// processLogin runs the login steps and then checks the response to the
+// last one. A message which doesn't match the step's pattern, or later
+// neither the success nor the failure pattern, is some other output, such
+// as the rest of the banner, and waits for the next message.
+func (s *ServerState) processLogin(message string) {
+	if s.loginStep > 0 && s.loginFailure != nil && s.loginFailure.MatchString(message) {
+		s.loginFailed(message)
+		return
+	}
+	if s.loginStep < len(s.loginSteps) {
+		step := s.loginSteps[s.loginStep]
+		if step.Expect != nil && !step.Expect.MatchString(message) {
+			return
+		}
+		s.sendLoginStep()
+		return
+	}
+	if s.loginSuccess != nil && !s.loginSuccess.MatchString(message) {
+		return
+	}
+	s.logger.Println("Login successful.")
+	s.loggedInOnce = true
+	s.setState(STATE_IDLE)
+	s.bracketOutput()
+	s.queueSetup()
+	// Rather than serving an empty list until the next tick.
+	s.pollWho()
+}
+
+// sendLoginStep sends the next line of the login and gives the MUSH
+// --login-timeout to answer it. The caller holds s.mu.
+func (s *ServerState) sendLoginStep() {
+	step := s.loginSteps[s.loginStep]
+	s.loginStep++
+	if len(s.loginSteps) > 1 {
+		s.logger.Printf("Login step %d of %d", s.loginStep, len(s.loginSteps))
+	}
+	s.send(step.Send)
+	s.stopAwait()
+	s.startAwait(s.config.LoginTimeout)
+}
+
+// loginTimedOut handles a login which got stuck for --login-timeout. Once,
+// the line which got no answer is sent again, or if the MUSH never showed
+// what the first step waits for, e.g. because --connect-screen doesn't fit
+// this game, the first line is sent anyway. The second time the login has
+// failed. The caller holds s.mu.
+func (s *ServerState) loginTimedOut() {
+	if s.loginRetried {
+		s.loginFailed(fmt.Sprintf("no answer within %s", s.config.LoginTimeout))
+		return
+	}
+	s.loginRetried = true
+	if s.loginStep == 0 {
+		s.logger.Println("The connect screen didn't show up, logging in anyway.")
+	} else {
+		s.logger.Printf("No answer to login step %d, sending it again.", s.loginStep)
+		s.loginStep--
+	}
+	s.sendLoginStep()
+}
+
+// loginFailed drops the connection and waits out the backoff before trying
+// again, so wrong credentials don't hammer the login. The caller holds s.mu.
+func (s *ServerState) loginFailed(message string) {
+	reason := s.redactLogin(strings.TrimSpace(strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]))
+	if len(s.loginSteps) > 1 {
+		s.logger.Printf("LOGIN FAILED at step %d of %d, check --login-step: %s", s.loginStep, len(s.loginSteps), reason)
+		s.recordError(fmt.Sprintf("login failed at step %d: %s", s.loginStep, reason))
+	} else {
+		s.logger.Println("LOGIN FAILED, check --connect-command:", reason)
+		s.recordError("login failed: " + reason)
+	}
+	s.metrics.RecordLoginFailure()
+	s.disconnect()
+	s.setState(STATE_LOGIN_FAILED)
+	s.scheduleReconnect()
+}
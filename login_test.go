@@ -0,0 +1,232 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultConnectScreen(t *testing.T) {
+	screen := regexp.MustCompile(testConfig(t).ConnectScreen)
+	for _, tc := range []struct {
+		fixture string
+		screen  bool
+	}{
+		{"banner.txt", true},
+		{"banner_tinymush.txt", true},
+		{"banner_character.txt", true},
+		{"banner_motd.txt", false},
+		{"login.txt", false},
+	} {
+		if got := screen.MatchString(readFixture(t, tc.fixture)); got != tc.screen {
+			t.Errorf("%s: connect screen %v, want %v", tc.fixture, got, tc.screen)
+		}
+	}
+}
+
+// sentLines returns what the server sent the fake since the last Forget.
+func sentLines(mush *fakeMUSH) []string {
+	var lines []string
+	for len(mush.lines) > 0 {
+		lines = append(lines, <-mush.lines)
+	}
+	return lines
+}
+
+// TestBannerInParts sends a banner in several messages. The connect command
+// waits for the part with the connect screen.
+func TestBannerInParts(t *testing.T) {
+	for _, fixture := range []string{"banner_tinymush.txt", "banner_character.txt"} {
+		t.Run(fixture, func(t *testing.T) {
+			mush := newFakeMUSH(t)
+			mush.banner = ""
+			ts := startServer(t, mush, serverEnv{})
+			waitFor(t, "the connection", mush.Connected)
+
+			mush.Announce(readFixture(t, "banner_motd.txt"))
+			time.Sleep(100 * time.Millisecond)
+			for _, line := range sentLines(mush) {
+				if strings.HasPrefix(line, "connect") {
+					t.Fatal("logged in during the MOTD")
+				}
+			}
+			if state := ts.currentState(); state != STATE_LOGGING_IN {
+				t.Errorf("state %s during the MOTD", state)
+			}
+			mush.Announce(readFixture(t, fixture))
+			mush.Expect("connect bot secret")
+			ts.waitForPlayers()
+		})
+	}
+}
+
+// TestLoginSuccessPattern waits for the MOTD after the connect command to
+// match --login-success, however many messages come before it.
+func TestLoginSuccessPattern(t *testing.T) {
+	mush := newFakeMUSH(t)
+	mush.Respond(func(line string) (string, bool) {
+		if strings.HasPrefix(line, "connect") {
+			mush.logins++
+			return "Please wait, loading your character...\r\n", true
+		}
+		return "", false
+	})
+	ts := startServer(t, mush, serverEnv{}, "--login-success", "^Last connect was")
+	mush.Expect("connect bot secret")
+	time.Sleep(100 * time.Millisecond)
+	if state := ts.currentState(); state != STATE_LOGGING_IN {
+		t.Fatalf("state %s before the MOTD", state)
+	}
+	mush.Announce(readFixture(t, "login.txt"))
+	ts.waitForPlayers()
+}
+
+func TestLoginTimeoutRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		banner string
+		// swallowed is how many connect commands go unanswered.
+		swallowed int
+		state     string
+	}{
+		{"the connect screen never shows", "Welcome.\r\n", 0, STATE_IDLE},
+		{"the first connect is lost", "", 1, STATE_IDLE},
+		{"no answer at all", "", 2, STATE_LOGIN_FAILED},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mush := newFakeMUSH(t)
+			if tc.banner != "" {
+				mush.banner = tc.banner
+			}
+			swallowed := 0
+			mush.Respond(func(line string) (string, bool) {
+				if strings.HasPrefix(line, "connect") && swallowed < tc.swallowed {
+					swallowed++
+					return "", true
+				}
+				return "", false
+			})
+			ts := startServer(t, mush, serverEnv{}, "--login-timeout", "200ms", "--reconnect-delay", "1h", "--reconnect-max-delay", "1h")
+			waitFor(t, "state "+tc.state, func() bool {
+				return ts.currentState() == tc.state
+			})
+			connects := 0
+			for _, line := range sentLines(mush) {
+				if line == "connect bot secret" {
+					connects++
+				}
+			}
+			want := min(tc.swallowed+1, 2)
+			if connects != want {
+				t.Errorf("sent the connect command %d times, want %d", connects, want)
+			}
+		})
+	}
+}
+
+// TestWhoRightAfterLogin polls WHO once logged in, rather than waiting for
+// the first tick. With the clock standing still, no tick is ever due.
+func TestWhoRightAfterLogin(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		who  string
+	}{
+		{"connect command", nil, "who"},
+		// At the connect screen, only the uppercase WHO works.
+		{"guest", []string{"--connect-command", ""}, "WHO"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mush := newFakeMUSH(t)
+			clock := newFakeClock()
+			args := append([]string{"--poll-interval", "1h"}, tc.args...)
+			ts := startServer(t, mush, serverEnv{now: clock.Now}, args...)
+			// A guest doesn't look up the locations.
+			var status StatusV2
+			waitFor(t, "the players", func() bool {
+				status = StatusV2{}
+				ts.get("/api/v2", &status)
+				return len(status.Players) == 3
+			})
+			if status.LastUpdated == nil || !status.LastUpdated.Equal(clock.Now()) {
+				t.Errorf("lastUpdated is %v, want %v", status.LastUpdated, clock.Now())
+			}
+			if _, logins := mush.Counts(); tc.args == nil && logins != 1 {
+				t.Errorf("%d logins, want 1", logins)
+			}
+
+			// And again after a reconnect.
+			mush.Forget()
+			mush.Kick()
+			mush.Expect(tc.who)
+		})
+	}
+}
+
+func TestReadConnectCmd(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"file":     "connect file secret\n",
+		"crlf":     "connect file secret\r\n",
+		"empty":    "",
+		"newlines": "\n\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(connectCmdEnv, "connect env secret")
+
+	for _, tc := range []struct {
+		name   string
+		config ServerConfig
+		want   string
+		err    string
+	}{
+		{"flag beats file", ServerConfig{ConnectCmd: "connect flag secret", ConnectFile: "file"}, "connect flag secret", ""},
+		{"file beats variable", ServerConfig{ConnectFile: "file"}, "connect file secret", ""},
+		{"variable", ServerConfig{}, "connect env secret", ""},
+		{"variable ignored with --login-step", ServerConfig{LoginSteps: []string{"=>connect bot"}}, "", ""},
+		{"CR LF trimmed", ServerConfig{ConnectFile: "crlf"}, "connect file secret", ""},
+		{"empty file", ServerConfig{ConnectFile: "empty"}, "", "is empty"},
+		{"only newlines", ServerConfig{ConnectFile: "newlines"}, "", "is empty"},
+		{"missing file", ServerConfig{ConnectFile: "missing"}, "", "no such file"},
+	} {
+		if tc.config.ConnectFile != "" {
+			tc.config.ConnectFile = filepath.Join(dir, tc.config.ConnectFile)
+		}
+		err := readConnectCmd(&tc.config)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), "--connect-command-file") || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want one with %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if tc.config.ConnectCmd != tc.want {
+			t.Errorf("%s: connect command %q, want %q", tc.name, tc.config.ConnectCmd, tc.want)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/jessevdk/go-flags"
 )
@@ -30,24 +31,22 @@ import (
 type MercConfig struct {
 }
 
-var Config struct {
-	Server ServerConfig `group:"Server config"`
-}
-
 func main() {
-	_, err := flags.Parse(&Config)
+	var config struct {
+		Server ServerConfig `group:"Server config"`
+	}
+	_, err := flags.Parse(&config)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = initServer(Config.Server, context.Background())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = initServer(config.Server, ctx)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	intChannel := make(chan os.Signal, 1)
-	signal.Notify(intChannel, os.Interrupt)
-	<-intChannel
-
 	log.Print("Exit")
 }
@@ -0,0 +1,381 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var metricsStates = []string{
+	STATE_NOT_CONNECTED,
+	STATE_CONNECTING,
+	STATE_LOGGING_IN,
+	STATE_LOGIN_FAILED,
+	STATE_IDLE,
+	STATE_AWAIT_WHO,
+	STATE_AWAIT_LOC,
+	STATE_KEEPALIVE,
+	STATE_SETUP,
+	STATE_PROBE,
+	STATE_QUITTING,
+}
+
+// Metrics holds the values exposed at /metrics. They are updated by the
+// state machine as things happen, never computed on scrape.
+type Metrics struct {
+	mu              sync.Mutex
+	game            string
+	players         int
+	locationPlayers map[string]int
+	state           string
+	connects        uint64
+	reconnects      uint64
+	whoAttempted    uint64
+	whoSucceeded    uint64
+	whoFailed       uint64
+	locationLookups uint64
+	locationFailed  uint64
+	awaitTimeouts   uint64
+	loginFailures   uint64
+	certFailures    uint64
+	tlsFailures     uint64
+	restarts        uint64
+	kicked          uint64
+	connErrors      uint64
+	dialFailures    uint64
+	proxyFailures   uint64
+	dnsFailures     uint64
+	throttled       uint64
+}
+
+// MetricsSnapshot is a copy of the counters in Metrics.
+type MetricsSnapshot struct {
+	ConnectAttempts       uint64 `json:"connectAttempts"`
+	Reconnects            uint64 `json:"reconnects"`
+	WhoPolls              uint64 `json:"whoPolls"`
+	WhoSucceeded          uint64 `json:"whoSucceeded"`
+	WhoParseFailures      uint64 `json:"whoParseFailures"`
+	LocationLookups       uint64 `json:"locationLookups"`
+	LocationParseFailures uint64 `json:"locationParseFailures"`
+	ResponseTimeouts      uint64 `json:"responseTimeouts"`
+	LoginFailures         uint64 `json:"loginFailures"`
+	CertificateFailures   uint64 `json:"certificateFailures"`
+	HandshakeFailures     uint64 `json:"handshakeFailures"`
+	Restarts              uint64 `json:"restarts"`
+	Disconnects           uint64 `json:"disconnects"`
+	ConnectionErrors      uint64 `json:"connectionErrors"`
+	DialFailures          uint64 `json:"dialFailures"`
+	ProxyFailures         uint64 `json:"proxyFailures"`
+	ResolveFailures       uint64 `json:"resolveFailures"`
+	ThrottledCommands     uint64 `json:"throttledCommands"`
+}
+
+// NewMetrics returns the metrics of a game, whose name labels the samples
+// if there are several games.
+func NewMetrics(game string) *Metrics {
+	return &Metrics{
+		game:            game,
+		locationPlayers: make(map[string]int),
+		state:           STATE_NOT_CONNECTED,
+	}
+}
+
+func (m *Metrics) SetState(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+func (m *Metrics) SetPlayers(players []*MushPlayer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.players = 0
+	m.locationPlayers = make(map[string]int)
+	for _, player := range players {
+		if player == nil {
+			continue
+		}
+		m.players++
+		m.locationPlayers[player.LocationName()]++
+	}
+}
+
+func (m *Metrics) Players() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.players
+}
+
+func (m *Metrics) State() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *Metrics) RecordConnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connects++
+	if m.connects > 1 {
+		m.reconnects++
+	}
+}
+
+func (m *Metrics) RecordWhoAttempt() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.whoAttempted++
+}
+
+func (m *Metrics) RecordWhoResult(ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok {
+		m.whoSucceeded++
+	} else {
+		m.whoFailed++
+	}
+}
+
+func (m *Metrics) RecordLocationResult(ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locationLookups++
+	if !ok {
+		m.locationFailed++
+	}
+}
+
+func (m *Metrics) RecordAwaitTimeout() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.awaitTimeouts++
+}
+
+func (m *Metrics) RecordLoginFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loginFailures++
+}
+
+func (m *Metrics) RecordCertificateFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certFailures++
+}
+
+func (m *Metrics) RecordHandshakeFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tlsFailures++
+}
+
+func (m *Metrics) RecordRestart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarts++
+}
+
+func (m *Metrics) RecordDisconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kicked++
+}
+
+func (m *Metrics) RecordConnectionError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connErrors++
+}
+
+func (m *Metrics) RecordDialFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dialFailures++
+}
+
+func (m *Metrics) RecordProxyFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxyFailures++
+}
+
+func (m *Metrics) RecordResolveFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dnsFailures++
+}
+
+func (m *Metrics) RecordThrottled() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.throttled++
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return MetricsSnapshot{
+		ConnectAttempts:       m.connects,
+		Reconnects:            m.reconnects,
+		WhoPolls:              m.whoAttempted,
+		WhoSucceeded:          m.whoSucceeded,
+		WhoParseFailures:      m.whoFailed,
+		LocationLookups:       m.locationLookups,
+		LocationParseFailures: m.locationFailed,
+		ResponseTimeouts:      m.awaitTimeouts,
+		LoginFailures:         m.loginFailures,
+		CertificateFailures:   m.certFailures,
+		HandshakeFailures:     m.tlsFailures,
+		Restarts:              m.restarts,
+		Disconnects:           m.kicked,
+		ConnectionErrors:      m.connErrors,
+		DialFailures:          m.dialFailures,
+		ProxyFailures:         m.proxyFailures,
+		ResolveFailures:       m.dnsFailures,
+		ThrottledCommands:     m.throttled,
+	}
+}
+
+// metricCounters are the counters at /metrics, in the order they are
+// written.
+var metricCounters = []struct {
+	name  string
+	help  string
+	value func(m *Metrics) uint64
+}{
+	{"tinymush_telnet_connect_attempts_total", "Number of telnet connection attempts since start.", func(m *Metrics) uint64 { return m.connects }},
+	{"tinymush_telnet_reconnects_total", "Number of telnet reconnects since start.", func(m *Metrics) uint64 { return m.reconnects }},
+	{"tinymush_who_polls_total", "Number of WHO polls sent to the MUSH.", func(m *Metrics) uint64 { return m.whoAttempted }},
+	{"tinymush_who_polls_succeeded_total", "Number of WHO responses parsed successfully.", func(m *Metrics) uint64 { return m.whoSucceeded }},
+	{"tinymush_who_polls_failed_total", "Number of WHO responses which could not be parsed.", func(m *Metrics) uint64 { return m.whoFailed }},
+	{"tinymush_location_lookups_total", "Number of location name responses received.", func(m *Metrics) uint64 { return m.locationLookups }},
+	{"tinymush_location_lookups_failed_total", "Number of location name responses which could not be parsed.", func(m *Metrics) uint64 { return m.locationFailed }},
+	{"tinymush_login_failures_total", "Number of connect commands the MUSH rejected.", func(m *Metrics) uint64 { return m.loginFailures }},
+	{"tinymush_telnet_certificate_failures_total", "Number of connections dropped because the MUSH's TLS certificate could not be verified.", func(m *Metrics) uint64 { return m.certFailures }},
+	{"tinymush_telnet_handshake_failures_total", "Number of connections whose TLS handshake failed for another reason, e.g. because the MUSH rejected our client certificate.", func(m *Metrics) uint64 { return m.tlsFailures }},
+	{"tinymush_restarts_total", "Number of MUSH restarts announced while logged in.", func(m *Metrics) uint64 { return m.restarts }},
+	{"tinymush_disconnects_total", "Number of times the MUSH disconnected us, e.g. with @boot.", func(m *Metrics) uint64 { return m.kicked }},
+	{"tinymush_telnet_errors_total", "Number of telnet connections lost to network errors.", func(m *Metrics) uint64 { return m.connErrors }},
+	{"tinymush_telnet_dial_failures_total", "Number of connection attempts which failed before reaching the MUSH.", func(m *Metrics) uint64 { return m.dialFailures }},
+	{"tinymush_telnet_proxy_failures_total", "Number of connection attempts which failed at the --telnet-proxy.", func(m *Metrics) uint64 { return m.proxyFailures }},
+	{"tinymush_telnet_resolve_failures_total", "Number of connection attempts which failed to resolve the MUSH's host name.", func(m *Metrics) uint64 { return m.dnsFailures }},
+	{"tinymush_commands_throttled_total", "Number of commands delayed by --command-rate.", func(m *Metrics) uint64 { return m.throttled }},
+	{"tinymush_response_timeouts_total", "Number of WHO and location lookups the MUSH didn't answer in time.", func(m *Metrics) uint64 { return m.awaitTimeouts }},
+}
+
+// writeMetrics writes the metrics of every game in the Prometheus text
+// format. Each metric is written once with a sample per game, labelled with
+// the game's name if it has one. They are rendered before anything is
+// written, since the state machines update their metrics while holding
+// their own lock, and a slow client would stall all of them.
+func writeMetrics(w io.Writer, all []*Metrics) {
+	var buffer bytes.Buffer
+	renderMetrics(&buffer, all)
+	w.Write(buffer.Bytes())
+}
+
+// renderMetrics renders the metrics of every game, holding all of their
+// locks so that the samples are of the same moment.
+func renderMetrics(w io.Writer, all []*Metrics) {
+	for _, m := range all {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+
+	writeHeader(w, "tinymush_players", "gauge", "Number of players currently connected to the MUSH.")
+	for _, m := range all {
+		fmt.Fprintf(w, "tinymush_players%s %d\n", m.labels(), m.players)
+	}
+
+	writeHeader(w, "tinymush_location_players", "gauge", "Number of players currently in each location.")
+	for _, m := range all {
+		locations := make([]string, 0, len(m.locationPlayers))
+		for location := range m.locationPlayers {
+			locations = append(locations, location)
+		}
+		sort.Strings(locations)
+		for _, location := range locations {
+			fmt.Fprintf(w, "tinymush_location_players%s %d\n", m.labels("location", location), m.locationPlayers[location])
+		}
+	}
+
+	writeHeader(w, "tinymush_telnet_state", "gauge", "Current state of the telnet connection, 1 for the active state.")
+	for _, m := range all {
+		for _, state := range metricsStates {
+			value := 0
+			if state == m.state {
+				value = 1
+			}
+			fmt.Fprintf(w, "tinymush_telnet_state%s %d\n", m.labels("state", state), value)
+		}
+	}
+
+	for _, counter := range metricCounters {
+		writeHeader(w, counter.name, "counter", counter.help)
+		for _, m := range all {
+			fmt.Fprintf(w, "%s%s %d\n", counter.name, m.labels(), counter.value(m))
+		}
+	}
+}
+
+// labels formats the label set of a sample from name and value pairs, with
+// the game first if there are several. The caller holds m.mu.
+func (m *Metrics) labels(pairs ...string) string {
+	if m.game != "" {
+		pairs = append([]string{"game", m.game}, pairs...)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", pairs[i], escapeLabel(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// serveMetrics serves the metrics of every game.
+func (s *ServerState) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	all := []*Metrics{s.metrics}
+	if s.games != nil {
+		all = all[:0]
+		for _, game := range s.games {
+			all = append(all, game.metrics)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, all)
+}
@@ -0,0 +1,98 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsSingleGame(t *testing.T) {
+	m := NewMetrics("")
+	m.SetPlayers([]*MushPlayer{{Name: "Alice", Location: `Bob's "den"`}})
+	m.RecordConnect()
+	var buffer bytes.Buffer
+	writeMetrics(&buffer, []*Metrics{m})
+	metrics := buffer.String()
+	for _, want := range []string{
+		"# TYPE tinymush_players gauge\ntinymush_players 1\n",
+		"tinymush_location_players{location=\"Bob's \\\"den\\\"\"} 1\n",
+		"tinymush_telnet_state{state=\"not_connected\"} 1\n",
+		"# TYPE tinymush_telnet_connect_attempts_total counter\ntinymush_telnet_connect_attempts_total 1\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("no %q in:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, "game=") {
+		t.Errorf("a single game is labelled:\n%s", metrics)
+	}
+}
+
+func TestWriteMetricsCountersOnce(t *testing.T) {
+	var buffer bytes.Buffer
+	writeMetrics(&buffer, []*Metrics{NewMetrics("a"), NewMetrics("b")})
+	for _, counter := range metricCounters {
+		if n := strings.Count(buffer.String(), "# HELP "+counter.name+" "); n != 1 {
+			t.Errorf("%s has %d HELP lines", counter.name, n)
+		}
+		for _, game := range []string{"a", "b"} {
+			if !strings.Contains(buffer.String(), counter.name+"{game=\""+game+"\"} 0\n") {
+				t.Errorf("%s has no sample for game %s", counter.name, game)
+			}
+		}
+	}
+}
+
+// blockingWriter blocks every write until release is closed, like a client
+// which doesn't read.
+type blockingWriter struct {
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	<-w.release
+	return len(p), nil
+}
+
+func TestWriteMetricsSlowClient(t *testing.T) {
+	all := []*Metrics{NewMetrics("a"), NewMetrics("b")}
+	w := &blockingWriter{writing: make(chan struct{}), release: make(chan struct{})}
+	defer close(w.release)
+	go writeMetrics(w, all)
+	<-w.writing
+
+	updated := make(chan struct{})
+	go func() {
+		for _, m := range all {
+			m.SetState(STATE_IDLE)
+		}
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("the state machines wait for a slow /metrics client")
+	}
+}
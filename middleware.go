@@ -0,0 +1,340 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Responses smaller than this are not worth compressing.
+const gzipMinSize = 1024
+
+const (
+	corsAllowedMethods = "GET, HEAD, OPTIONS"
+	corsMaxAge         = "600"
+)
+
+// bufferedResponseWriter holds back the status and body of a response so a
+// middleware can decide how to send it once the handler is done.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// withGzip compresses the response of next if the client accepts it. The
+// response is buffered, so this must not wrap streaming handlers.
+func withGzip(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next(w, r)
+			return
+		}
+
+		buffered := &bufferedResponseWriter{ResponseWriter: w}
+		next(buffered, r)
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+
+		body := buffered.body.Bytes()
+		header := w.Header()
+		size := len(body)
+		if r.Method == http.MethodHead {
+			// Announce the same encoding a GET would get.
+			size, _ = strconv.Atoi(header.Get("Content-Length"))
+		}
+		if size < gzipMinSize || header.Get("Content-Encoding") != "" {
+			w.WriteHeader(buffered.status)
+			w.Write(body)
+			return
+		}
+
+		if header.Get("Content-Type") == "" && len(body) > 0 {
+			header.Set("Content-Type", http.DetectContentType(body))
+		}
+		// The compressed body is a different representation, so a strong
+		// validator no longer applies to it.
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		w.WriteHeader(buffered.status)
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		gz := gzip.NewWriter(w)
+		gz.Write(body)
+		gz.Close()
+	}
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		key, value, found := strings.Cut(strings.TrimSpace(params), "=")
+		if found && strings.TrimSpace(key) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// withCORS adds CORS headers for origins listed in the config and answers
+// preflight requests. Other origins get no CORS headers at all.
+func (s *ServerState) withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(s.config.CORSOrigins) == 0 {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		wildcard := slices.Contains(s.config.CORSOrigins, "*")
+		if !wildcard && !slices.Contains(s.config.CORSOrigins, origin) {
+			next(w, r)
+			return
+		}
+
+		if wildcard {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// withAuth rejects requests which don't carry one of the configured API
+// tokens. Without configured tokens every request is let through.
+func (s *ServerState) withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.config.APITokens) == 0 {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.isValidToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tinymush"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isValidToken compares against every configured token in constant time.
+// Hashing first makes the comparison independent of the token lengths.
+func (s *ServerState) isValidToken(token string) bool {
+	given := sha256.Sum256([]byte(token))
+	valid := 0
+	for _, configured := range s.config.APITokens {
+		expected := sha256.Sum256([]byte(configured))
+		valid |= subtle.ConstantTimeCompare(given[:], expected[:])
+	}
+	return valid == 1
+}
+
+// withAdminAuth protects mutating endpoints with HTTP basic auth. Admin
+// endpoints are disabled entirely unless admin credentials are configured.
+func (s *ServerState) withAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminUser == "" {
+			writeJSONError(w, http.StatusForbidden, "Admin endpoints are disabled")
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || !s.isValidAdmin(user, pass) {
+			s.logRequest(r, slog.LevelWarn, "Admin authentication failed for %s %s", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Basic realm="tinymush admin", charset="UTF-8"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		s.logRequest(r, slog.LevelInfo, "Admin %s: %s %s", user, r.Method, r.URL.Path)
+		next(w, r)
+	}
+}
+
+func (s *ServerState) isValidAdmin(user string, pass string) bool {
+	givenUser, givenPass := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))
+	expectedUser, expectedPass := sha256.Sum256([]byte(s.config.AdminUser)), sha256.Sum256([]byte(s.config.AdminPass))
+	userOk := subtle.ConstantTimeCompare(givenUser[:], expectedUser[:])
+	passOk := subtle.ConstantTimeCompare(givenPass[:], expectedPass[:])
+	return userOk&passOk == 1
+}
+
+// statusRecorder remembers the status and size of a response for the access
+// log. It passes flushing and hijacking through so streaming endpoints and
+// WebSockets keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.size += n
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// withBasePath strips the configured base path from requests, so routes can
+// be registered without it, and drops trailing slashes so /api/ works like
+// /api.
+func (s *ServerState) withBasePath(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if base := s.config.BasePath; base != "" {
+			if path == base {
+				// Relative links on the status page need the slash.
+				target := base + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+			rest, ok := strings.CutPrefix(path, base+"/")
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, "Not found")
+				return
+			}
+			path = "/" + rest
+		}
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+
+		if path != r.URL.Path {
+			stripped := *r
+			url := *r.URL
+			url.Path = path
+			url.RawPath = ""
+			stripped.URL = &url
+			r = &stripped
+		}
+		next(w, r)
+	}
+}
+
+// withAccessLog logs every request once it is done, which for streaming
+// endpoints is when the connection closes.
+func (s *ServerState) withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.accessLog == nil {
+			next(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.accessLog.Info("request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("size", rec.size),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", r.RemoteAddr),
+			slog.String(requestIDAttr, requestID(r.Context())),
+		)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// publicRoutes are the GET routes which don't show any players and so
+// don't take the API token.
+var publicRoutes = []string{"/badge.svg", "/healthz", "/readyz"}
+
+func TestAPITokenCoversRoutes(t *testing.T) {
+	s := newTestState(t, "--api-token", "secret")
+	router := s.routes()
+	for _, route := range router.docs {
+		if route.Method != http.MethodGet || route.Doc.Auth == AUTH_ADMIN {
+			continue
+		}
+		path := route.Pattern
+		if isPrefixPattern(path) {
+			path += "someone"
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		public := slices.Contains(publicRoutes, route.Pattern)
+		if public && recorder.Code == http.StatusUnauthorized {
+			t.Errorf("%s wants a token", path)
+		}
+		if !public && recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s answered %d without a token", path, recorder.Code)
+		}
+		if !public && route.Doc.Auth != AUTH_TOKEN {
+			t.Errorf("the OpenAPI document doesn't list the token for %s", path)
+		}
+	}
+}
+
+func TestAPITokenAccepted(t *testing.T) {
+	s := newTestState(t, "--api-token", "old", "--api-token", "new")
+	handler := s.handler(s.routes())
+	for _, tc := range []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic b2xkOg==", http.StatusUnauthorized},
+		{"Bearer old", http.StatusOK},
+		{"Bearer new", http.StatusOK},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/api/badge", nil)
+		if tc.header != "" {
+			request.Header.Set("Authorization", tc.header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != tc.want {
+			t.Errorf("Authorization %q: got %d, want %d", tc.header, recorder.Code, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: no WWW-Authenticate header", tc.header)
+		}
+	}
+}
+
+// manyPlayers returns enough players for a status above gzipMinSize.
+func manyPlayers(count int) []*MushPlayer {
+	players := make([]*MushPlayer, count)
+	for i := range players {
+		players[i] = &MushPlayer{Name: fmt.Sprintf("Player%03d", i), Location: "#10", Doing: "Waiting for the ferry"}
+	}
+	return players
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, manyPlayers(100)...)
+	handler := s.handler(s.routes())
+
+	for _, path := range []string{"/api", "/api/v2", "/api?pretty=1", "/api/rooms"} {
+		plain := serve(handler, httptest.NewRequest(http.MethodGet, path, nil))
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept-Encoding", "br;q=1, gzip;q=0.8")
+		compressed := serve(handler, request)
+
+		if got := compressed.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("%s: Content-Encoding %q", path, got)
+			continue
+		}
+		if !slices.Contains(compressed.Header().Values("Vary"), "Accept-Encoding") {
+			t.Errorf("%s: Vary %v", path, compressed.Header().Values("Vary"))
+		}
+		if compressed.Body.Len() >= plain.Body.Len() {
+			t.Errorf("%s: %d compressed bytes of %d", path, compressed.Body.Len(), plain.Body.Len())
+		}
+		reader, err := gzip.NewReader(compressed.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !bytes.Equal(body, plain.Body.Bytes()) {
+			t.Errorf("%s: decompressed body differs:\n%s\nwant:\n%s", path, body, plain.Body)
+		}
+		if !json.Valid(body) {
+			t.Errorf("%s: decompressed body is no JSON", path)
+		}
+	}
+}
+
+func TestGzipSkipped(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker", Location: "#10"})
+	handler := s.handler(s.routes())
+
+	for _, tc := range []struct {
+		name           string
+		acceptEncoding string
+	}{
+		{"tiny payload", "gzip"},
+		{"not accepted", "br"},
+		{"refused", "gzip;q=0"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/api", nil)
+		request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		response := serve(handler, request)
+		if got := response.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%s: Content-Encoding %q", tc.name, got)
+		}
+		if !json.Valid(response.Body.Bytes()) {
+			t.Errorf("%s: body is no JSON: %s", tc.name, response.Body)
+		}
+	}
+}
+
+func TestGzipNotOnStreams(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, manyPlayers(100)...)
+	server := httptest.NewServer(s.handler(s.routes()))
+	defer server.Close()
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if got := response.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("/api/events: Content-Encoding %q", got)
+	}
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	if err != nil || line != "event: snapshot\n" {
+		t.Errorf("/api/events: first line %q, %v", line, err)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The separators in an MSSP subnegotiation.
+const (
+	MSSP_VAR = 1
+	MSSP_VAL = 2
+)
+
+// MSSPVariable is one value the MUSH reported through MSSP. A variable with
+// several values, like PORT, is reported once for each.
+type MSSPVariable struct {
+	Name  string `json:"name" xml:"name"`
+	Value string `json:"value" xml:"value"`
+}
+
+// parseMSSP splits the data of an MSSP subnegotiation, without the option
+// byte, into its variables.
+func parseMSSP(data []byte) []MSSPVariable {
+	variables := []MSSPVariable{}
+	var name string
+	var field strings.Builder
+	var kind byte
+	end := func() {
+		switch kind {
+		case MSSP_VAR:
+			name = field.String()
+		case MSSP_VAL:
+			if name != "" {
+				variables = append(variables, MSSPVariable{Name: name, Value: field.String()})
+			}
+		}
+		field.Reset()
+	}
+	for _, b := range data {
+		if b == MSSP_VAR || b == MSSP_VAL {
+			end()
+			kind = b
+			continue
+		}
+		field.WriteByte(b)
+	}
+	end()
+	return variables
+}
+
+// ServerInfo is what the MUSH reported about itself through MSSP, in the
+// /api/v2 response. The well known variables are taken out, all of them
+// are listed in Variables.
+type ServerInfo struct {
+	Name     string `json:"name,omitempty" xml:"name,omitempty"`
+	Codebase string `json:"codebase,omitempty" xml:"codebase,omitempty"`
+	// Players is the count at the time of the report, which is only sent
+	// once, right after connecting.
+	Players *int `json:"players,omitempty" xml:"players,omitempty"`
+	// UpSince is when the MUSH was started.
+	UpSince   *time.Time     `json:"upSince,omitempty" xml:"upSince,omitempty"`
+	Variables []MSSPVariable `json:"variables" xml:"variables>variable"`
+}
+
+// msspValue returns the first value of a variable.
+func msspValue(variables []MSSPVariable, name string) (string, bool) {
+	for _, variable := range variables {
+		if variable.Name == name {
+			return variable.Value, true
+		}
+	}
+	return "", false
+}
+
+// serverInfo returns what the MUSH reported through MSSP, or nil if it
+// didn't.
+func serverInfo(variables []MSSPVariable) *ServerInfo {
+	if len(variables) == 0 {
+		return nil
+	}
+	info := &ServerInfo{Variables: variables}
+	info.Name, _ = msspValue(variables, "NAME")
+	info.Codebase, _ = msspValue(variables, "CODEBASE")
+	if value, ok := msspValue(variables, "PLAYERS"); ok {
+		if players, err := strconv.Atoi(value); err == nil {
+			info.Players = &players
+		}
+	}
+	if value, ok := msspValue(variables, "UPTIME"); ok {
+		if started, err := strconv.ParseInt(value, 10, 64); err == nil && started > 0 {
+			upSince := time.Unix(started, 0)
+			info.UpSince = &upSince
+		}
+	}
+	return info
+}
+
+// serverInfo returns the last MSSP report of the session, in UTF-8. The
+// caller holds s.mu.
+func (s *ServerState) serverInfo() *ServerInfo {
+	if s.telnetOptions == nil {
+		return nil
+	}
+	variables := s.telnetOptions.MSSP()
+	decoded := make([]MSSPVariable, 0, len(variables))
+	for _, variable := range variables {
+		decoded = append(decoded, MSSPVariable{
+			Name:  s.charset.Decode(variable.Name),
+			Value: s.charset.Decode(variable.Value),
+		})
+	}
+	return serverInfo(decoded)
+}
+
+// checkMSSPPlayers compares the player count of the MSSP report with the
+// first WHO after it. They were taken moments apart, so a difference means
+// that one of them leaves players out, or that the WHO isn't parsed right.
+// The caller holds s.mu.
+func (s *ServerState) checkMSSPPlayers(players int) {
+	if s.msspChecked {
+		return
+	}
+	info := s.serverInfo()
+	if info == nil || info.Players == nil {
+		return
+	}
+	s.msspChecked = true
+	if *info.Players != players {
+		s.logger.Printf("MSSP reports %d players, but the WHO lists %d.", *info.Players, players)
+	}
+}
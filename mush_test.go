@@ -0,0 +1,365 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readFixture returns a file from testdata with the line endings a MUSH
+// sends.
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(string(data), "\n", "\r\n")
+}
+
+var locationLookup = regexp.MustCompile(`^"(#\d+)"\[name\((#\d+)\)\]$`)
+
+// fakeMUSH is a TinyMUSH on a loopback port which answers the commands the
+// state machine sends from the fixtures in testdata. It also serves as the
+// dialer, so the server can be given any --host.
+type fakeMUSH struct {
+	t        *testing.T
+	listener net.Listener
+	// lines gets every line the server sends, without the line ending.
+	lines chan string
+
+	mu     sync.Mutex
+	banner string
+	login  string
+	who    string
+	rooms  map[string]string
+	// prompt ends everything the MUSH sends with GA, so the server
+	// doesn't have to wait for the rest of the output.
+	prompt bool
+	// respond, if set, answers a line instead of the defaults, as it is.
+	// It reports false for lines it leaves to them.
+	respond func(line string) (string, bool)
+	// prefix and suffix are set with OUTPUTPREFIX and OUTPUTSUFFIX and
+	// put around the default answers.
+	prefix string
+	suffix string
+	conns  []net.Conn
+	dials  int
+	logins int
+	// replies are the answers to option negotiation, as in
+	// TelnetOptions.
+	replies []string
+	// echo, if set, is the format with which the MUSH echoes each line
+	// before its answer.
+	echo string
+}
+
+func newFakeMUSH(t *testing.T) *fakeMUSH {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeMUSH{
+		t:        t,
+		listener: listener,
+		lines:    make(chan string, 1024),
+		banner:   readFixture(t, "banner.txt"),
+		login:    readFixture(t, "login.txt"),
+		who:      readFixture(t, "who.txt"),
+		rooms:    map[string]string{"#10": "Town Square", "#11": "The Docks"},
+		prompt:   true,
+	}
+	go m.serve()
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *fakeMUSH) Addr() string {
+	return m.listener.Addr().String()
+}
+
+// Dial connects to the fake whatever the address.
+func (m *fakeMUSH) Dial(network string, address string) (net.Conn, error) {
+	m.mu.Lock()
+	m.dials++
+	m.mu.Unlock()
+	return net.Dial("tcp", m.Addr())
+}
+
+func (m *fakeMUSH) Close() {
+	m.listener.Close()
+	m.Kick()
+}
+
+func (m *fakeMUSH) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		m.conns = append(m.conns, conn)
+		banner := m.banner
+		m.mu.Unlock()
+		go m.handle(conn, banner)
+	}
+}
+
+// write sends text to conn, followed by GA if the MUSH has a prompt.
+func (m *fakeMUSH) write(conn net.Conn, text string) {
+	m.mu.Lock()
+	prompt := m.prompt
+	m.mu.Unlock()
+	data := []byte(text)
+	if prompt {
+		data = append(data, TELNET_IAC, TELNET_GA)
+	}
+	conn.Write(data)
+}
+
+func (m *fakeMUSH) handle(conn net.Conn, banner string) {
+	defer conn.Close()
+	m.write(conn, banner)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := m.negotiated(strings.TrimRight(scanner.Text(), "\r"))
+		select {
+		case m.lines <- line:
+		default:
+		}
+		answer, quit := m.answer(line)
+		m.mu.Lock()
+		if m.echo != "" && !quit {
+			answer = fmt.Sprintf(m.echo, line) + answer
+		}
+		m.mu.Unlock()
+		if answer != "" {
+			m.write(conn, answer)
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// negotiated takes the answers to option negotiation, which the server
+// sends in between its commands, out of line and keeps them.
+func (m *fakeMUSH) negotiated(line string) string {
+	var text []byte
+	for i := 0; i < len(line); i++ {
+		if line[i] != TELNET_IAC || i+1 == len(line) {
+			text = append(text, line[i])
+			continue
+		}
+		switch line[i+1] {
+		case TELNET_WILL, TELNET_WONT, TELNET_DO, TELNET_DONT:
+			if i+2 < len(line) {
+				m.mu.Lock()
+				m.replies = append(m.replies, telnetVerbs[line[i+1]]+" "+telnetOptionName(line[i+2]))
+				m.mu.Unlock()
+			}
+			i += 2
+		default:
+			text = append(text, line[i+1])
+			i++
+		}
+	}
+	return string(text)
+}
+
+// Replies returns the answers to option negotiation the server sent.
+func (m *fakeMUSH) Replies() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.replies)
+}
+
+// answer returns what the MUSH says to line and whether it closes the
+// connection afterwards.
+func (m *fakeMUSH) answer(line string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.respond != nil {
+		if answer, ok := m.respond(line); ok {
+			return answer, false
+		}
+	}
+	command, arg, _ := strings.Cut(line, " ")
+	switch {
+	case command == "connect":
+		m.logins++
+		m.prefix, m.suffix = "", ""
+		return m.login, false
+	// Like TinyMUSH, the prefix goes out before the command runs and the
+	// suffix after it, so each only shows from the next command on.
+	case command == "OUTPUTPREFIX":
+		output := m.bracket("")
+		m.prefix = arg
+		return output, false
+	case command == "OUTPUTSUFFIX":
+		output := m.bracket("")
+		m.suffix = arg
+		return output, false
+	case command == "@doing":
+		return m.bracket("Set.\r\n"), false
+	case strings.EqualFold(line, "WHO"):
+		return m.bracket(m.who), false
+	case line == "QUIT":
+		return "Goodbye.\r\n", true
+	}
+	if match := locationLookup.FindStringSubmatch(line); match != nil {
+		return m.bracket(fmt.Sprintf("You say, \"%s\"%s\"\r\n", match[1], m.rooms[match[2]])), false
+	}
+	return m.bracket("Huh?  (Type \"help\" for help.)\r\n"), false
+}
+
+// bracket puts the OUTPUTPREFIX and OUTPUTSUFFIX lines around output, if
+// they were set. The caller holds m.mu.
+func (m *fakeMUSH) bracket(output string) string {
+	if m.prefix != "" {
+		output = m.prefix + "\r\n" + output
+	}
+	if m.suffix != "" {
+		output += m.suffix + "\r\n"
+	}
+	return output
+}
+
+// Respond replaces the defaults for the lines respond answers.
+func (m *fakeMUSH) Respond(respond func(line string) (string, bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.respond = respond
+}
+
+// SetWho replaces the WHO fixture.
+func (m *fakeMUSH) SetWho(name string) {
+	who := readFixture(m.t, name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.who = who
+}
+
+// Announce sends text to every connection, like a @wall or a restart.
+func (m *fakeMUSH) Announce(text string) {
+	m.mu.Lock()
+	conns := slices.Clone(m.conns)
+	m.mu.Unlock()
+	for _, conn := range conns {
+		m.write(conn, text)
+	}
+}
+
+// Kick drops every connection, like a MUSH which crashed.
+func (m *fakeMUSH) Kick() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+	m.conns = nil
+}
+
+// Connected reports whether the server is connected.
+func (m *fakeMUSH) Connected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns) > 0
+}
+
+// Counts returns how often the server dialed and logged in.
+func (m *fakeMUSH) Counts() (dials int, logins int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dials, m.logins
+}
+
+// Forget drops the lines the server sent so far, so Expect only looks at
+// what comes next.
+func (m *fakeMUSH) Forget() {
+	for {
+		select {
+		case <-m.lines:
+		default:
+			return
+		}
+	}
+}
+
+// Expect waits for the server to send line, skipping everything before it.
+func (m *fakeMUSH) Expect(line string) {
+	m.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-m.lines:
+			if got == line {
+				return
+			}
+		case <-timeout:
+			m.t.Fatalf("the server never sent %q", line)
+		}
+	}
+}
+
+// waitFor polls condition until it holds, or fails the test after a few
+// seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeClock is a clock for the state machine which only moves when told
+// to. Timers still run on the real one.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,313 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AUTH_NONE  = ""
+	AUTH_TOKEN = "token"
+	AUTH_ADMIN = "admin"
+)
+
+// RouteDoc describes a route for the OpenAPI document. The router requires
+// one for every route, so the document can't miss any.
+type RouteDoc struct {
+	Summary string
+	// Auth is AUTH_TOKEN for routes behind --api-token and AUTH_ADMIN for
+	// admin routes.
+	Auth   string
+	Params []ParamDoc
+	// Status is the status of a successful response, 200 if unset.
+	Status int
+	// ContentTypes are the types a successful response can have,
+	// application/json if unset.
+	ContentTypes []string
+	// Response is a value of the type a successful JSON response is
+	// encoded from. Its schema is derived from the Go type.
+	Response any
+}
+
+type ParamDoc struct {
+	Name string
+	// In is "query", or "path" for the remainder of a prefix pattern.
+	In          string
+	Type        string
+	Description string
+}
+
+// oneOf documents a response which has one of several types.
+type oneOf []any
+
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Servers    []OpenAPIServer                         `json:"servers,omitempty"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIOperation struct {
+	Summary    string                     `json:"summary"`
+	Parameters []OpenAPIParameter         `json:"parameters,omitempty"`
+	Responses  map[string]OpenAPIResponse `json:"responses"`
+	Security   []map[string][]string      `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type OpenAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]OpenAPIMedia `json:"content,omitempty"`
+}
+
+type OpenAPIMedia struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema               `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGenerator derives JSON schemas from Go types the way encoding/json
+// serializes them. Named structs end up in schemas and are referenced.
+type schemaGenerator struct {
+	schemas map[string]*Schema
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		inner := g.schema(t.Elem())
+		if inner.Ref != "" {
+			return &Schema{AllOf: []*Schema{inner}, Nullable: true}
+		}
+		inner.Nullable = true
+		return inner
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first in case the type refers to itself.
+			g.schemas[t.Name()] = &Schema{}
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	return &Schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			// encoding/json inlines the fields of embedded structs.
+			for embeddedName, embedded := range g.structSchema(field.Type).Properties {
+				schema.Properties[embeddedName] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = g.schema(field.Type)
+	}
+	return schema
+}
+
+func (g *schemaGenerator) responseSchema(response any) *Schema {
+	if alternatives, ok := response.(oneOf); ok {
+		schema := &Schema{}
+		for _, alternative := range alternatives {
+			schema.OneOf = append(schema.OneOf, g.responseSchema(alternative))
+		}
+		return schema
+	}
+	return g.schema(reflect.TypeOf(response))
+}
+
+// openAPIPath turns a router pattern into an OpenAPI path. Prefix patterns
+// end in their path parameter.
+func openAPIPath(pattern string, params []ParamDoc) string {
+	for _, param := range params {
+		if param.In == "path" {
+			return pattern + "{" + param.Name + "}"
+		}
+	}
+	return pattern
+}
+
+// OpenAPI describes every route registered with the router.
+func (rt *Router) OpenAPI(basePath string) OpenAPIDocument {
+	generator := &schemaGenerator{schemas: make(map[string]*Schema)}
+	errorSchema := generator.schema(reflect.TypeOf(ErrorResponse{}))
+	doc := OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   "TinyMUSH status server",
+			Version: strconv.Itoa(API_V2),
+		},
+		Paths: make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: generator.schemas,
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"apiToken": {Type: "http", Scheme: "bearer"},
+				"admin":    {Type: "http", Scheme: "basic"},
+			},
+		},
+	}
+	if basePath != "" {
+		doc.Servers = []OpenAPIServer{{URL: basePath}}
+	}
+
+	for _, route := range rt.docs {
+		path := openAPIPath(route.Pattern, route.Doc.Params)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+
+		operation := &OpenAPIOperation{
+			Summary: route.Doc.Summary,
+			Responses: map[string]OpenAPIResponse{
+				"default": {
+					Description: "Error",
+					Content:     map[string]OpenAPIMedia{"application/json": {Schema: errorSchema}},
+				},
+			},
+		}
+		for _, param := range route.Doc.Params {
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+				Name:        param.Name,
+				In:          param.In,
+				Description: param.Description,
+				Required:    param.In == "path",
+				Schema:      &Schema{Type: param.Type},
+			})
+		}
+		switch route.Doc.Auth {
+		case AUTH_TOKEN:
+			operation.Security = []map[string][]string{{"apiToken": {}}}
+		case AUTH_ADMIN:
+			operation.Security = []map[string][]string{{"admin": {}}}
+		}
+
+		status := route.Doc.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		contentTypes := route.Doc.ContentTypes
+		if contentTypes == nil {
+			contentTypes = []string{"application/json"}
+		}
+		response := OpenAPIResponse{
+			Description: http.StatusText(status),
+			Content:     make(map[string]OpenAPIMedia),
+		}
+		for _, contentType := range contentTypes {
+			var media OpenAPIMedia
+			if contentType == "application/json" && route.Doc.Response != nil {
+				media.Schema = generator.responseSchema(route.Doc.Response)
+			}
+			response.Content[contentType] = media
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+
+		doc.Paths[path][strings.ToLower(route.Method)] = operation
+	}
+	return doc
+}
+
+func (s *ServerState) serveOpenAPI(router *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.MarshalIndent(router.OpenAPI(s.config.BasePath), "", "  ")
+		if err != nil {
+			writeInternalError(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s := newTestState(t, "--enable-pprof")
+	router := s.routes()
+	var doc OpenAPIDocument
+	response := serve(s.handler(router), httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if err := json.Unmarshal(response.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	documented := 0
+	for _, operations := range doc.Paths {
+		documented += len(operations)
+	}
+	if documented != len(router.docs) {
+		t.Errorf("%d operations documented for %d routes", documented, len(router.docs))
+	}
+	for _, route := range router.docs {
+		path := openAPIPath(route.Pattern, route.Doc.Params)
+		operation := doc.Paths[path][strings.ToLower(route.Method)]
+		if operation == nil {
+			t.Errorf("%s %s is missing", route.Method, path)
+			continue
+		}
+		if operation.Summary == "" {
+			t.Errorf("%s %s has no summary", route.Method, path)
+		}
+		auth := len(operation.Security) > 0
+		if auth != (route.Doc.Auth != AUTH_NONE) {
+			t.Errorf("%s %s: security %v for auth %q", route.Method, path, operation.Security, route.Doc.Auth)
+		}
+	}
+}
+
+// TestOpenAPIValid checks the parts of the OpenAPI 3.0 specification which
+// the generator could get wrong: required fields, path parameters and that
+// every reference resolves.
+func TestOpenAPIValid(t *testing.T) {
+	s := newTestState(t, "--enable-pprof")
+	var doc map[string]any
+	if err := json.Unmarshal(serve(s.handler(s.routes()), httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil)).Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := doc["openapi"].(string); !strings.HasPrefix(version, "3.0.") {
+		t.Errorf("openapi %v", doc["openapi"])
+	}
+	info, _ := doc["info"].(map[string]any)
+	if info["title"] == "" || info["version"] == "" {
+		t.Errorf("info %v", info)
+	}
+	components, _ := doc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	schemes, _ := components["securitySchemes"].(map[string]any)
+
+	// Every $ref anywhere in the document points into the schemas.
+	var walk func(where string, value any)
+	walk = func(where string, value any) {
+		switch value := value.(type) {
+		case map[string]any:
+			if ref, ok := value["$ref"].(string); ok {
+				name, found := strings.CutPrefix(ref, "#/components/schemas/")
+				if _, ok := schemas[name]; !found || !ok {
+					t.Errorf("%s: unresolved $ref %s", where, ref)
+				}
+			}
+			for key, inner := range value {
+				walk(where+"/"+key, inner)
+			}
+		case []any:
+			for _, inner := range value {
+				walk(where, inner)
+			}
+		}
+	}
+	walk("#", doc)
+
+	methods := map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true}
+	paths, _ := doc["paths"].(map[string]any)
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %s doesn't start with a slash", path)
+		}
+		templated := map[string]bool{}
+		for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+			templated[match[1]] = true
+		}
+		for method, value := range item.(map[string]any) {
+			where := method + " " + path
+			if !methods[method] {
+				t.Errorf("%s: unknown method", where)
+			}
+			operation := value.(map[string]any)
+			responses, _ := operation["responses"].(map[string]any)
+			if len(responses) == 0 {
+				t.Errorf("%s: no responses", where)
+			}
+			for status, response := range responses {
+				if description, _ := response.(map[string]any)["description"].(string); description == "" {
+					t.Errorf("%s: response %s has no description", where, status)
+				}
+			}
+			inPath := map[string]bool{}
+			parameters, _ := operation["parameters"].([]any)
+			for _, value := range parameters {
+				param := value.(map[string]any)
+				name, _ := param["name"].(string)
+				switch param["in"] {
+				case "path":
+					inPath[name] = true
+					if param["required"] != true {
+						t.Errorf("%s: path parameter %s isn't required", where, name)
+					}
+				case "query", "header", "cookie":
+				default:
+					t.Errorf("%s: parameter %s is in %v", where, name, param["in"])
+				}
+				if param["schema"] == nil {
+					t.Errorf("%s: parameter %s has no schema", where, name)
+				}
+			}
+			for name := range templated {
+				if !inPath[name] {
+					t.Errorf("%s: path parameter %s isn't declared", where, name)
+				}
+			}
+			for name := range inPath {
+				if !templated[name] {
+					t.Errorf("%s: parameter %s isn't in the path", where, name)
+				}
+			}
+			security, _ := operation["security"].([]any)
+			for _, requirement := range security {
+				for scheme := range requirement.(map[string]any) {
+					if _, ok := schemes[scheme]; !ok {
+						t.Errorf("%s: unknown security scheme %s", where, scheme)
+					}
+				}
+			}
+		}
+	}
+}
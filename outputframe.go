@@ -0,0 +1,53 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "strings"
+
+// bracketOutput has the MUSH put a line before and after the output of
+// every command from now on, with OUTPUTPREFIX and OUTPUTSUFFIX, if
+// --output-brackets is given. The lines carry a new ID every session, so
+// no player can fake them. Both commands go through the queue like the
+// setup commands, ahead of them, so they count against --command-rate and
+// nothing else is in flight meanwhile. The caller holds s.mu.
+func (s *ServerState) bracketOutput() {
+	if !s.config.OutputBracket {
+		return
+	}
+	id := newRequestID()[:12]
+	s.outputPrefix = "--- status " + id + " begin ---"
+	s.outputSuffix = "--- status " + id + " end ---"
+	s.enqueue(Command{Text: "OUTPUTPREFIX " + s.outputPrefix, Expect: STATE_SETUP, Timeout: setupWait})
+	s.enqueue(Command{Text: "OUTPUTSUFFIX " + s.outputSuffix, Expect: STATE_SETUP, Timeout: setupWait})
+}
+
+// unbracket returns the output between the bracket lines once the suffix
+// arrived. Anything around them is unsolicited, e.g. a page or someone
+// connecting, and is dropped. The caller holds s.mu.
+func (s *ServerState) unbracket() (string, bool) {
+	_, output, ok := strings.Cut(s.partial, s.outputPrefix)
+	if ok {
+		output, _, ok = strings.Cut(output, s.outputSuffix)
+	}
+	if !ok {
+		s.armQuiet()
+		return "", false
+	}
+	s.partial = ""
+	return strings.TrimLeft(output, "\r\n"), true
+}
@@ -0,0 +1,54 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputBrackets(t *testing.T) {
+	mush := newFakeMUSH(t)
+	var order []string
+	mush.Respond(func(line string) (string, bool) {
+		command, _, _ := strings.Cut(line, " ")
+		switch command {
+		case "OUTPUTPREFIX", "OUTPUTSUFFIX", "@doing":
+			order = append(order, command)
+		case "who":
+			order = append(order, command)
+			// A page right before the output mustn't end up in the WHO.
+			page := "Someone pages: Player Name 1 logged in.\r\n"
+			return page + mush.bracket(mush.who), true
+		}
+		return "", false
+	})
+	ts := startServer(t, mush, serverEnv{}, "--output-brackets", "--doing", "Status bot")
+	ts.waitForPlayers()
+
+	mush.mu.Lock()
+	defer mush.mu.Unlock()
+	want := []string{"OUTPUTPREFIX", "OUTPUTSUFFIX", "@doing", "who"}
+	if len(order) < len(want) || strings.Join(order[:len(want)], " ") != strings.Join(want, " ") {
+		t.Errorf("commands went out as %v, want %v first", order, want)
+	}
+	prefix, suffix := mush.prefix, mush.suffix
+	if !strings.HasPrefix(prefix, "--- status ") || strings.TrimSuffix(prefix, "begin ---") != strings.TrimSuffix(suffix, "end ---") {
+		t.Errorf("brackets %q and %q don't match", prefix, suffix)
+	}
+}
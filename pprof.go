@@ -0,0 +1,80 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// servePprof serves the net/http/pprof handlers below /debug/pprof/, and
+// the index at /debug/pprof itself, which is where requests for
+// /debug/pprof/ end up once withBasePath trimmed the slash. Importing
+// net/http/pprof also registers them on http.DefaultServeMux, which is never
+// served.
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		// The command line contains the connect command and its password.
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
+
+// startPprofServer serves pprof on its own address, which has to be a
+// loopback one since it isn't authenticated.
+func startPprofServer(address string) (*http.Server, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("pprof address %s is not a loopback address", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	router := NewRouter()
+	router.Handle(http.MethodGet, "/debug/pprof", servePprof, RouteDoc{Summary: "pprof index"})
+	router.Handle(http.MethodGet, "/debug/pprof/", servePprof, RouteDoc{Summary: "pprof profiles"})
+	server := &http.Server{
+		Handler:           router,
+		ReadHeaderTimeout: 3 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Println("pprof server failed:", err)
+		}
+	}()
+	log.Println("Serving pprof on", address)
+	return server, nil
+}
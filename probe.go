@@ -0,0 +1,88 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PROBE_MARKER is replaced by a fresh marker in --probe-command.
+const PROBE_MARKER = "{marker}"
+
+// probeTicks returns the channel on which loopWorker sends a probe, or nil
+// without --probe-interval.
+func (s *ServerState) probeTicks() (<-chan time.Time, func()) {
+	if s.config.ProbeInterval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(s.config.ProbeInterval)
+	return ticker.C, ticker.Stop
+}
+
+// sendProbe queues --probe-command with a new marker, which the MUSH has to
+// send back within --probe-timeout. TCP keepalive only tells that the host
+// is up, a MUSH which hangs keeps the connection open without answering.
+// The probe goes through the queue like every command, so it never comes
+// between a WHO and its response. The caller holds s.mu.
+func (s *ServerState) sendProbe() {
+	if !isLoggedIn(s.currentState) || s.guest() || s.currentState == STATE_PROBE {
+		return
+	}
+	if slices.ContainsFunc(s.queue, func(command Command) bool { return command.Expect == STATE_PROBE }) {
+		return
+	}
+	s.probeMarker = "STATUSPING-" + newRequestID()[:12]
+	text := strings.ReplaceAll(s.config.ProbeCmd, PROBE_MARKER, s.probeMarker)
+	s.enqueue(Command{Text: text, Expect: STATE_PROBE, Timeout: s.config.ProbeTimeout})
+}
+
+// isProbeAnswer reports whether the marker of the probe came back as a line
+// of its own. A line which only contains it is the MUSH echoing the probe
+// command, which even a hung game may still do.
+func (s *ServerState) isProbeAnswer(text string) bool {
+	for _, line := range strings.Split(normalizeNewlines(text), "\n") {
+		if strings.TrimSpace(line) == s.probeMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// processProbe handles the output after a probe. Anything else the MUSH
+// sends in the meantime is ignored while waiting for the marker. The caller
+// holds s.mu.
+func (s *ServerState) processProbe(text string) {
+	if !s.isProbeAnswer(text) {
+		return
+	}
+	s.setState(STATE_IDLE)
+	s.dispatch()
+}
+
+// probeTimedOut drops the connection when the marker didn't come back in
+// time. The caller holds s.mu.
+func (s *ServerState) probeTimedOut() {
+	message := fmt.Sprintf("no answer to the probe within %s", s.config.ProbeTimeout)
+	s.logger.Printf("MUSH seems hung, %s.", message)
+	s.recordError("connection dead: " + message)
+	s.metrics.RecordConnectionError()
+	s.disconnect()
+}
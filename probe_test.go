@@ -0,0 +1,96 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsProbeAnswer(t *testing.T) {
+	s := newTestState(t)
+	s.probeMarker = "STATUSPING-0123456789ab"
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"STATUSPING-0123456789ab\r\n", true},
+		{"  STATUSPING-0123456789ab  \r\n", true},
+		{"Someone pages you.\r\nSTATUSPING-0123456789ab\r\n", true},
+		{"STATUSPING-0123456789ab\n\r", true},
+		// The echoed command isn't an answer.
+		{"think STATUSPING-0123456789ab\r\n", false},
+		{"Pong: STATUSPING-0123456789ab\r\n", false},
+		{"STATUSPING-0123456789abc\r\n", false},
+		{"STATUSPING-012345", false},
+		{"", false},
+	} {
+		if got := s.isProbeAnswer(tc.text); got != tc.want {
+			t.Errorf("isProbeAnswer(%q) = %t, want %t", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestProbeEchoIsNoAnswer(t *testing.T) {
+	mush := newFakeMUSH(t)
+	// A hung game whose network layer still echoes what it gets.
+	mush.Respond(func(line string) (string, bool) {
+		if strings.HasPrefix(line, "think ") {
+			return line + "\r\n", true
+		}
+		return "", false
+	})
+	ts := startServer(t, mush, serverEnv{tick: 50 * time.Millisecond}, "--echo-match", "off", "--probe-interval", "100ms", "--probe-timeout", "200ms")
+	ts.waitForPlayers()
+	waitFor(t, "a reconnect after the probe timed out", func() bool {
+		dials, _ := mush.Counts()
+		return dials >= 2
+	})
+	ts.state.mu.RLock()
+	defer ts.state.mu.RUnlock()
+	if ts.state.lastError == nil || !strings.Contains(ts.state.lastError.Message, "probe") {
+		t.Errorf("last error is %+v, want the probe", ts.state.lastError)
+	}
+}
+
+func TestProbeAnswered(t *testing.T) {
+	mush := newFakeMUSH(t)
+	probes := make(chan string, 16)
+	mush.Respond(func(line string) (string, bool) {
+		marker, ok := strings.CutPrefix(line, "think ")
+		if !ok {
+			return "", false
+		}
+		select {
+		case probes <- marker:
+		default:
+		}
+		return marker + "\r\n", true
+	})
+	ts := startServer(t, mush, serverEnv{}, "--probe-interval", "100ms", "--probe-timeout", "200ms", "--poll-interval", "1h")
+	ts.waitForPlayers()
+	first := <-probes
+	time.Sleep(500 * time.Millisecond)
+	if dials, _ := mush.Counts(); dials != 1 {
+		t.Errorf("dialed %d times although every probe was answered", dials)
+	}
+	if second := <-probes; second == first {
+		t.Errorf("the marker %s was used twice", first)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+}
+
+// ProxyError is a failure to get a connection through --telnet-proxy, as
+// opposed to one of the MUSH itself.
+type ProxyError struct {
+	Err error
+}
+
+func (e *ProxyError) Error() string {
+	return "proxy: " + e.Err.Error()
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// proxyDialer wraps the errors of a proxy's dialer in ProxyError. A SOCKS
+// dialer gets timeout as its context's deadline, which it sets on the
+// connection for the handshake.
+type proxyDialer struct {
+	dialer  proxy.Dialer
+	timeout time.Duration
+}
+
+func (d proxyDialer) Dial(network string, address string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if contextDialer, ok := d.dialer.(proxy.ContextDialer); ok && d.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+		conn, err = contextDialer.DialContext(ctx, network, address)
+	} else {
+		conn, err = d.dialer.Dial(network, address)
+	}
+	if err != nil {
+		return nil, &ProxyError{Err: err}
+	}
+	return conn, nil
+}
+
+// telnetDialer returns the dialer for the MUSH connection, through the proxy
+// if one is given. timeout, unless 0, bounds the exchange with the proxy
+// which sets up the tunnel. The proxy URL is never logged since it may hold
+// a password.
+func telnetDialer(proxyURL string, direct proxy.Dialer, timeout time.Duration) (proxy.Dialer, error) {
+	if proxyURL == "" {
+		return direct, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --telnet-proxy: %w", err)
+	}
+	dialer, err := proxy.FromURL(u, direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --telnet-proxy: %w", err)
+	}
+	if connect, ok := dialer.(*httpConnectDialer); ok {
+		connect.timeout = timeout
+	}
+	return proxyDialer{dialer, timeout}, nil
+}
+
+// httpConnectDialer tunnels through an HTTP proxy with CONNECT. timeout,
+// unless 0, bounds the CONNECT request and its response.
+type httpConnectDialer struct {
+	address string
+	auth    string
+	forward proxy.Dialer
+	timeout time.Duration
+}
+
+func newHTTPConnectDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	if u.Port() == "" {
+		return nil, fmt.Errorf("proxy %s has no port", u.Hostname())
+	}
+	d := &httpConnectDialer{address: u.Host, forward: forward}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := u.User.Username() + ":" + password
+		d.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	return d, nil
+}
+
+func (d *httpConnectDialer) Dial(network string, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.address)
+	if err != nil {
+		return nil, err
+	}
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.auth != "" {
+		request.Header.Set("Proxy-Authorization", d.auth)
+	}
+	if d.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The body of a successful CONNECT is the tunnel, so it is left alone
+	// rather than closed, which would read it to the end.
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT to %s answered %s", address, response.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	// The MUSH may already have sent its welcome screen, which the reader
+	// has buffered along with the response.
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads through a bufio.Reader which was wrapped around the
+// connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,150 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// silentListener accepts connections and never says a word.
+func silentListener(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		for conn := range accepted {
+			conn.Close()
+		}
+	})
+	return listener.Addr().String()
+}
+
+func TestDialTimeoutCoversExchanges(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	address := silentListener(t)
+	direct := &net.Dialer{Timeout: timeout}
+	for _, tc := range []struct {
+		name string
+		dial func() (net.Conn, error)
+		// as is the type the error has to be.
+		as any
+	}{
+		{
+			name: "tls handshake",
+			dial: func() (net.Conn, error) {
+				return dialTelnet(address, direct, &tls.Config{InsecureSkipVerify: true}, timeout)
+			},
+			as: new(*HandshakeError),
+		},
+		{
+			name: "http connect",
+			dial: func() (net.Conn, error) {
+				dialer, err := telnetDialer("http://"+address, direct, timeout)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return dialTelnet("mush.example:4201", dialer, nil, timeout)
+			},
+			as: new(*ProxyError),
+		},
+		{
+			name: "socks5",
+			dial: func() (net.Conn, error) {
+				dialer, err := telnetDialer("socks5://"+address, direct, timeout)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return dialTelnet("mush.example:4201", dialer, nil, timeout)
+			},
+			as: new(*ProxyError),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			conn, err := tc.dial()
+			if err == nil {
+				conn.Close()
+				t.Fatal("dialed a peer which never answers")
+			}
+			if took := time.Since(start); took > 10*timeout {
+				t.Errorf("gave up after %s, want about %s", took, timeout)
+			}
+			if !errors.As(err, tc.as) {
+				t.Errorf("got %T %v", err, err)
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Errorf("%v is not a timeout", err)
+			}
+		})
+	}
+}
+
+func TestDialTimeoutClearedOnTunnel(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1024))
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		// The MUSH takes its time with the banner.
+		time.Sleep(4 * timeout)
+		conn.Write([]byte("Welcome\r\n"))
+	}()
+
+	dialer, err := telnetDialer("http://"+listener.Addr().String(), &net.Dialer{}, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialTelnet("mush.example:4201", dialer, nil, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buffer := make([]byte, 16)
+	n, err := conn.Read(buffer)
+	if err != nil || string(buffer[:n]) != "Welcome\r\n" {
+		t.Errorf("read %q, %v through the tunnel", buffer[:n], err)
+	}
+}
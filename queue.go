@@ -0,0 +1,109 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Command is a command for the MUSH along with the state in which the state
+// machine waits for its response.
+type Command struct {
+	Text    string
+	Expect  string
+	Timeout time.Duration
+	// Location is the dbref a location lookup is for.
+	Location string
+}
+
+func whoCommand(timeout time.Duration) Command {
+	return Command{Text: "who", Expect: STATE_AWAIT_WHO, Timeout: timeout}
+}
+
+func locationCommand(dbref string, timeout time.Duration) Command {
+	return Command{
+		Text:     fmt.Sprintf("\"%s\"[name(%s)]", dbref, dbref),
+		Expect:   STATE_AWAIT_LOC,
+		Timeout:  timeout,
+		Location: dbref,
+	}
+}
+
+// enqueue queues a command and sends it right away if nothing else is in
+// flight. The caller holds s.mu.
+func (s *ServerState) enqueue(command Command) {
+	s.queue = append(s.queue, command)
+	s.dispatch()
+}
+
+// dispatch sends the next queued command once the state machine is idle
+// again, i.e. the previous command's response arrived or timed out. Every
+// command goes through here, so there is never more than one in flight and
+// each response is handled by the state that asked for it. It is also where
+// --command-rate is enforced. The caller holds s.mu.
+func (s *ServerState) dispatch() {
+	for s.currentState == STATE_IDLE && len(s.queue) > 0 {
+		if delay := s.throttleDelay(); delay > 0 {
+			s.holdDispatch(delay)
+			return
+		}
+		command := s.queue[0]
+		s.queue = s.queue[1:]
+		// It may have been looked up since, e.g. as a duplicate.
+		if command.Location != "" {
+			if _, ok := s.locations.Lookup(command.Location); ok {
+				continue
+			}
+		}
+		if command.Expect == STATE_AWAIT_WHO {
+			s.metrics.RecordWhoAttempt()
+		}
+		s.inFlight = command
+		s.setState(command.Expect)
+		s.send(command.Text)
+		s.startAwait(command.Timeout)
+	}
+}
+
+// isQueued reports whether a command waits to be sent.
+func (s *ServerState) isQueued(text string) bool {
+	return slices.ContainsFunc(s.queue, func(queued Command) bool {
+		return queued.Text == text
+	})
+}
+
+// queuedLookups returns the number of location lookups waiting to be sent.
+func (s *ServerState) queuedLookups() int {
+	count := 0
+	for _, command := range s.queue {
+		if command.Location != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// dropLookups forgets the queued location lookups; they are queued again
+// after the next WHO.
+func (s *ServerState) dropLookups() {
+	s.queue = slices.DeleteFunc(s.queue, func(command Command) bool {
+		return command.Location != ""
+	})
+}
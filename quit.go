@@ -0,0 +1,47 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "time"
+
+// quitTimeout is how long shutdown waits for the MUSH to close the
+// connection after QUIT.
+const quitTimeout = 2 * time.Second
+
+// quit logs out of the MUSH if logged in, so the character doesn't linger
+// on its WHO list after we're gone, and waits for the MUSH to close the
+// connection or quitTimeout. The quitting state keeps the state machine
+// from sending anything after QUIT.
+func (s *ServerState) quit() {
+	s.mu.Lock()
+	if !isLoggedIn(s.currentState) {
+		s.mu.Unlock()
+		return
+	}
+	s.logger.Println("Logging out...")
+	s.setState(STATE_QUITTING)
+	s.send("QUIT")
+	done := s.sessionDone
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(quitTimeout):
+		s.logger.Println("The MUSH didn't close the connection after QUIT.")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Backoff computes the delays between reconnects. Each one doubles the
+// previous, up to max, and is then jittered down by up to half so that
+// several instances don't hammer a restarted MUSH in lockstep.
+type Backoff struct {
+	base     time.Duration
+	max      time.Duration
+	attempts int
+	// last is the delay Next returned last, zero after a reset.
+	last time.Duration
+}
+
+func NewBackoff(base time.Duration, max time.Duration) *Backoff {
+	return &Backoff{base: base, max: max}
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	delay := b.max
+	// Past 30 doublings the shift overflows; any sane cap is below that.
+	if b.attempts < 30 {
+		delay = min(b.base<<b.attempts, b.max)
+	}
+	b.attempts++
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+	b.last = delay
+	return delay
+}
+
+func (b *Backoff) Reset() {
+	b.attempts = 0
+	b.last = 0
+}
+
+// scheduleReconnect arranges for loopWorker to dial again once the backoff
+// delay has passed. Until then the tick leaves the connection alone. The
+// caller holds s.mu.
+func (s *ServerState) scheduleReconnect() {
+	if s.giveUpStartup() {
+		return
+	}
+	s.resetBackoffIfStable()
+	if s.reconnectTimer != nil {
+		s.reconnectTimer.Stop()
+	}
+	delay := s.backoff.Next()
+	s.logger.Printf("Reconnecting in %s", delay.Round(time.Millisecond))
+	s.nextReconnect = s.now().Add(delay)
+	s.reconnectTimer = time.AfterFunc(delay, func() {
+		select {
+		case s.reconnect <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// resetBackoffIfStable starts the reconnect delay over if the session which
+// is ending lasted for --reconnect-stable-after. A MUSH which lets us in
+// only to drop us moments later, because of a sitelock or a full game, keeps
+// the delay growing like one which refuses the connection. The caller holds
+// s.mu.
+func (s *ServerState) resetBackoffIfStable() {
+	if s.sessionStart.IsZero() {
+		return
+	}
+	lasted := s.now().Sub(s.sessionStart)
+	if lasted >= s.config.StableAfter {
+		s.backoff.Reset()
+		return
+	}
+	s.logger.Printf("The session only lasted %s, keeping the reconnect delay growing.", lasted.Round(time.Second))
+}
+
+// dialFailed handles a connection attempt which never got as far as a
+// telnet session. It tears the attempt down, which ends its sendWorker, and
+// backs off like any other lost connection. Nothing happens if the session
+// was cancelled in the meantime. The caller holds s.mu.
+func (s *ServerState) dialFailed(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	var proxyErr *ProxyError
+	var dnsErr *net.DNSError
+	var handshakeErr *HandshakeError
+	if errors.As(err, &handshakeErr) {
+		// Connecting worked, the certificates didn't.
+		s.logger.Printf("Could not set up TLS with %s: %v", s.config.TelnetHost, err)
+		s.recordError(err.Error())
+		if isCertificateError(err) {
+			s.metrics.RecordCertificateFailure()
+		} else {
+			s.metrics.RecordHandshakeFailure()
+		}
+	} else if errors.As(err, &proxyErr) {
+		// The MUSH may be fine, it's the way there which is broken.
+		s.logger.Printf("Could not connect to %s through the proxy: %v", s.config.TelnetHost, proxyErr.Err)
+		s.recordError(err.Error())
+		s.metrics.RecordProxyFailure()
+	} else if errors.As(err, &dnsErr) {
+		s.logger.Printf("Could not resolve %s: %v", s.config.TelnetHost, err)
+		s.recordError("resolve: " + err.Error())
+		s.metrics.RecordResolveFailure()
+	} else {
+		s.logger.Printf("Could not connect to %s: %v", s.config.TelnetHost, err)
+		s.recordError("dial: " + err.Error())
+		s.metrics.RecordDialFailure()
+	}
+	s.dialError = s.lastError
+	s.cancelSession()
+	s.cancelSession = nil
+	s.setState(STATE_NOT_CONNECTED)
+	s.scheduleReconnect()
+}
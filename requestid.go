@@ -0,0 +1,87 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDAttr is the log attribute of the request ID, in the access log
+// as in the lines about a request, so the two can be joined.
+const requestIDAttr = "request_id"
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID, taken from X-Request-ID if the
+// client or a proxy sent a sane one, and returns it in the same header. The
+// ID is stored in the request context for logging.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the logger for the lines about the requests to a
+// game, which names the game if it has a name.
+func requestLogger(logger *slog.Logger, game string) *slog.Logger {
+	if game != "" {
+		return logger.With(slog.String("game", game))
+	}
+	return logger
+}
+
+// logRequest logs a message about a request at the given level, with its ID
+// as an attribute of its own.
+func (s *ServerState) logRequest(r *http.Request, level slog.Level, format string, args ...any) {
+	s.requestLog.Log(r.Context(), level, fmt.Sprintf(format, args...), slog.String(requestIDAttr, requestID(r.Context())))
+}
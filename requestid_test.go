@@ -0,0 +1,81 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	handler := withRequestID(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestID(r.Context())))
+	})
+	for _, tc := range []struct {
+		header string
+		kept   bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"with space", false},
+		{strings.Repeat("x", 129), false},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			request.Header.Set(requestIDHeader, tc.header)
+		}
+		response := serve(handler, request)
+		id := response.Header().Get(requestIDHeader)
+		if id != response.Body.String() {
+			t.Errorf("%q: header %q, context %q", tc.header, id, response.Body.String())
+		}
+		if (id == tc.header) != tc.kept || id == "" {
+			t.Errorf("%q: request ID %q", tc.header, id)
+		}
+	}
+}
+
+func TestLogRequestAttribute(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestState(t)
+	s.accessLog = slog.New(slog.NewTextHandler(&buf, nil))
+	s.requestLog = requestLogger(s.accessLog, "other")
+	handler := withRequestID(s.withAccessLog(func(w http.ResponseWriter, r *http.Request) {
+		s.logRequest(r, slog.LevelError, "Failed to encode status: %v", "oops")
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(requestIDHeader, "abc-123")
+	serve(handler, request)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %q, want the handler's and the access log's line", lines)
+	}
+	for _, want := range []string{"level=ERROR", `msg="Failed to encode status: oops"`, "game=other", "request_id=abc-123"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("handler log line %q lacks %s", lines[0], want)
+		}
+	}
+	if !strings.Contains(lines[1], "msg=request") || !strings.Contains(lines[1], "request_id=abc-123") {
+		t.Errorf("access log line %q lacks the same request_id", lines[1])
+	}
+}
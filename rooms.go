@@ -0,0 +1,86 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Room struct {
+	// Location is the friendly name, or the dbref while it is unknown.
+	Location string   `json:"location"`
+	Dbref    string   `json:"dbref"`
+	Count    int      `json:"count"`
+	Players  []string `json:"players"`
+}
+
+// groupRooms groups the players by location, busiest rooms first. The
+// known locations without players are included as empty rooms if given.
+func groupRooms(players []*MushPlayer, empty map[string]string) []Room {
+	rooms := make(map[string]*Room)
+	for dbref, name := range empty {
+		rooms[dbref] = &Room{Location: name, Dbref: dbref, Players: make([]string, 0)}
+	}
+	for _, player := range sortPlayers(players, compareNames) {
+		dbref := string(player.Location)
+		room, ok := rooms[dbref]
+		if !ok {
+			room = &Room{Location: player.LocationName(), Dbref: dbref}
+			rooms[dbref] = room
+		}
+		room.Count++
+		room.Players = append(room.Players, player.Name)
+	}
+
+	grouped := make([]Room, 0, len(rooms))
+	for _, room := range rooms {
+		grouped = append(grouped, *room)
+	}
+	slices.SortFunc(grouped, func(a, b Room) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		if c := strings.Compare(strings.ToLower(a.Location), strings.ToLower(b.Location)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Dbref, b.Dbref)
+	})
+	return grouped
+}
+
+func (s *ServerState) serveRooms(w http.ResponseWriter, r *http.Request) {
+	var empty map[string]string
+	if all, _ := strconv.ParseBool(r.URL.Query().Get("all")); all {
+		empty, _ = s.locations.Snapshot()
+	}
+	s.mu.RLock()
+	rooms := groupRooms(s.mushState.Players, empty)
+	s.mu.RUnlock()
+
+	jsonBody, err := json.Marshal(rooms)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
@@ -0,0 +1,83 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRooms(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	s.locations.Set("#10", "Town Square")
+	s.locations.Set("#11", "The Docks")
+	s.locations.Set("#12", "Attic")
+	setPlayers(s,
+		&MushPlayer{Name: "walker", Location: "#10"},
+		&MushPlayer{Name: "Rhee", Location: "#10"},
+		&MushPlayer{Name: "Sam", Location: "#11"},
+		&MushPlayer{Name: "Mira", Location: "#99"},
+		&MushPlayer{Name: "Ann", Location: "#99"},
+		&MushPlayer{Name: "Guest"},
+	)
+	handler := s.handler(s.routes())
+
+	for _, tc := range []struct {
+		path string
+		want []Room
+	}{
+		{"/api/rooms", []Room{
+			{Location: "#99", Dbref: "#99", Count: 2, Players: []string{"Ann", "Mira"}},
+			{Location: "Town Square", Dbref: "#10", Count: 2, Players: []string{"Rhee", "walker"}},
+			{Location: "", Dbref: "", Count: 1, Players: []string{"Guest"}},
+			{Location: "The Docks", Dbref: "#11", Count: 1, Players: []string{"Sam"}},
+		}},
+		{"/api/rooms?all=1", []Room{
+			{Location: "#99", Dbref: "#99", Count: 2, Players: []string{"Ann", "Mira"}},
+			{Location: "Town Square", Dbref: "#10", Count: 2, Players: []string{"Rhee", "walker"}},
+			{Location: "", Dbref: "", Count: 1, Players: []string{"Guest"}},
+			{Location: "The Docks", Dbref: "#11", Count: 1, Players: []string{"Sam"}},
+			{Location: "Attic", Dbref: "#12", Count: 0, Players: []string{}},
+		}},
+	} {
+		response := serve(handler, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s answered %d", tc.path, response.Code)
+		}
+		var rooms []Room
+		if err := json.Unmarshal(response.Body.Bytes(), &rooms); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rooms, tc.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tc.path, rooms, tc.want)
+		}
+	}
+}
+
+func TestRoomsEmpty(t *testing.T) {
+	s := newTestState(t)
+	setPlayers(s)
+	response := serve(s.handler(s.routes()), httptest.NewRequest(http.MethodGet, "/api/rooms", nil))
+	if response.Body.String() != "[]" {
+		t.Errorf("no players: %s", response.Body)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests by path and method. Patterns ending in a slash
+// match everything below them, all others, and the root, match exactly.
+// Requests with a method the route doesn't handle get a 405 with an Allow
+// header, and OPTIONS is answered for every route.
+type Router struct {
+	routes   map[string]map[string]http.HandlerFunc
+	prefixes []string
+	// docs lists every route in registration order for the OpenAPI
+	// document.
+	docs []routeEntry
+}
+
+type routeEntry struct {
+	Method  string
+	Pattern string
+	Doc     RouteDoc
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[string]map[string]http.HandlerFunc),
+	}
+}
+
+func (rt *Router) Handle(method string, pattern string, handler http.HandlerFunc, doc RouteDoc) {
+	rt.docs = append(rt.docs, routeEntry{method, pattern, doc})
+	methods, ok := rt.routes[pattern]
+	if !ok {
+		methods = make(map[string]http.HandlerFunc)
+		rt.routes[pattern] = methods
+		if isPrefixPattern(pattern) {
+			rt.prefixes = append(rt.prefixes, pattern)
+			// Longest prefix first, so the most specific route wins.
+			sort.Slice(rt.prefixes, func(i, j int) bool {
+				return len(rt.prefixes[i]) > len(rt.prefixes[j])
+			})
+		}
+	}
+	methods[method] = handler
+}
+
+func isPrefixPattern(pattern string) bool {
+	return pattern != "/" && strings.HasSuffix(pattern, "/")
+}
+
+func (rt *Router) match(path string) map[string]http.HandlerFunc {
+	if methods, ok := rt.routes[path]; ok && !isPrefixPattern(path) {
+		return methods
+	}
+	for _, prefix := range rt.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return rt.routes[prefix]
+		}
+	}
+	return nil
+}
+
+// allow lists the methods of a route for the Allow header.
+func allow(methods map[string]http.HandlerFunc) string {
+	allowed := []string{http.MethodOptions}
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	if _, ok := methods[http.MethodGet]; ok {
+		if _, ok := methods[http.MethodHead]; !ok {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	methods := rt.match(r.URL.Path)
+	if methods == nil {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	handler, ok := methods[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		// net/http drops the body of HEAD responses for us.
+		handler, ok = methods[http.MethodGet]
+	}
+	if ok {
+		handler(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", allow(methods))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
@@ -0,0 +1,117 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// named answers with its name, so a test can tell which route matched.
+func named(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Handle(http.MethodGet, "/", named("root"), RouteDoc{})
+	router.Handle(http.MethodGet, "/api", named("api"), RouteDoc{})
+	router.Handle(http.MethodGet, "/api/players/", named("players"), RouteDoc{})
+	router.Handle(http.MethodGet, "/api/players/admin/", named("admin players"), RouteDoc{})
+	router.Handle(http.MethodPost, "/api/refresh", named("refresh"), RouteDoc{})
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+		body   string
+		allow  string
+	}{
+		{http.MethodGet, "/", http.StatusOK, "root", ""},
+		{http.MethodGet, "/api", http.StatusOK, "api", ""},
+		{http.MethodHead, "/api", http.StatusOK, "api", ""},
+		{http.MethodGet, "/api/players/Walker", http.StatusOK, "players", ""},
+		{http.MethodGet, "/api/players/admin/Walker", http.StatusOK, "admin players", ""},
+		{http.MethodPost, "/api/refresh", http.StatusOK, "refresh", ""},
+		{http.MethodPost, "/api", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS"},
+		{http.MethodDelete, "/api/players/Walker", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/api/refresh", http.StatusMethodNotAllowed, "", "OPTIONS, POST"},
+		{http.MethodOptions, "/api", http.StatusNoContent, "", "GET, HEAD, OPTIONS"},
+		{http.MethodOptions, "/api/refresh", http.StatusNoContent, "", "OPTIONS, POST"},
+		{http.MethodGet, "/api/", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/api/players", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/nowhere", http.StatusNotFound, "", ""},
+	} {
+		response := serve(router, httptest.NewRequest(tc.method, tc.path, nil))
+		if response.Code != tc.status {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, response.Code, tc.status)
+			continue
+		}
+		if got := response.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+		if tc.body != "" && response.Body.String() != tc.body {
+			t.Errorf("%s %s: routed to %q, want %q", tc.method, tc.path, response.Body, tc.body)
+		}
+		if response.Code >= 400 {
+			var body ErrorResponse
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || body.Error.Code != errorCode(tc.status) {
+				t.Errorf("%s %s: error body %s", tc.method, tc.path, response.Body)
+			}
+		}
+	}
+}
+
+// TestGetAPI checks GET /api through every middleware.
+func TestGetAPI(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker", Location: "#10"})
+	handler := s.handler(s.routes())
+
+	get := serve(handler, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if get.Code != http.StatusOK || get.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Fatalf("GET /api: %d with %s", get.Code, get.Header().Get("Content-Type"))
+	}
+	var status StatusV1
+	if err := json.Unmarshal(get.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Players) != 1 || status.Players[0].Name != "Walker" || status.Players[0].Location != "#10" {
+		t.Errorf("GET /api: %+v", status)
+	}
+
+	head := serve(handler, httptest.NewRequest(http.MethodHead, "/api", nil))
+	if head.Code != http.StatusOK || head.Body.Len() > 0 {
+		t.Errorf("HEAD /api: %d with %d bytes", head.Code, head.Body.Len())
+	}
+	for _, header := range []string{"Content-Type", "Content-Length", "ETag"} {
+		if head.Header().Get(header) != get.Header().Get(header) {
+			t.Errorf("HEAD /api: %s %q, GET has %q", header, head.Header().Get(header), get.Header().Get(header))
+		}
+	}
+
+	post := serve(handler, httptest.NewRequest(http.MethodPost, "/api", nil))
+	if post.Code != http.StatusMethodNotAllowed || post.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("POST /api: %d with Allow %q", post.Code, post.Header().Get("Allow"))
+	}
+}
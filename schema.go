@@ -0,0 +1,167 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "time"
+
+const (
+	API_V1 = 1
+	API_V2 = 2
+)
+
+// StatusV1 is the schema served at /api/v1 and /api. It is frozen: existing
+// scrapers rely on it staying exactly like this, so new fields go to
+// StatusV2 only. Fields which are only returned when asked for, like
+// locationRef, don't change what existing scrapers see.
+type StatusV1 struct {
+	Players []PlayerV1 `json:"players" xml:"players>player"`
+}
+
+type PlayerV1 struct {
+	Name     string `json:"name" xml:"name"`
+	Location string `json:"location" xml:"location"`
+	// LocationRef is the raw dbref, only set with ?raw=1.
+	LocationRef string `json:"locationRef,omitempty" xml:"locationRef,omitempty"`
+}
+
+// StatusV2 is the schema served at /api/v2, which gains new fields as they
+// are added.
+type StatusV2 struct {
+	Players []PlayerV2 `json:"players" xml:"players>player"`
+	// Total is the number of matching players before pagination.
+	Total int `json:"total" xml:"total"`
+	// Stale is set when the server isn't logged in to the MUSH or the last
+	// WHO is older than --stale-after.
+	Stale bool `json:"stale" xml:"stale"`
+	// Connection is one of disconnected, connecting, logging_in,
+	// login_failed and connected.
+	Connection string `json:"connection" xml:"connection"`
+	// LastUpdated is the time of the last parsed WHO, null before the
+	// first one.
+	LastUpdated *time.Time `json:"lastUpdated" xml:"lastUpdated,omitempty"`
+	// Server is the MUSH host, left out with --redact-server.
+	Server string `json:"server,omitempty" xml:"server,omitempty"`
+	// ServerInfo is what the MUSH reports about itself through MSSP, left
+	// out if it doesn't.
+	ServerInfo *ServerInfo `json:"serverInfo,omitempty" xml:"serverInfo,omitempty"`
+}
+
+type PlayerV2 struct {
+	Name     string `json:"name" xml:"name"`
+	Location string `json:"location,omitempty" xml:"location,omitempty"`
+	Doing    string `json:"doing" xml:"doing"`
+	// LocationRef is the raw dbref, only set with ?raw=1.
+	LocationRef string `json:"locationRef,omitempty" xml:"locationRef,omitempty"`
+}
+
+func playerV1(player *MushPlayer, raw bool) PlayerV1 {
+	response := PlayerV1{
+		Name:     player.Name,
+		Location: player.LocationName(),
+	}
+	if raw {
+		response.LocationRef = string(player.Location)
+	}
+	return response
+}
+
+func playerV2(player *MushPlayer, raw bool) PlayerV2 {
+	response := PlayerV2{
+		Name:     player.Name,
+		Location: player.LocationName(),
+		Doing:    player.Doing,
+	}
+	if raw {
+		response.LocationRef = string(player.Location)
+	}
+	return response
+}
+
+// playerResponse converts a player to the schema of the given API version.
+// With raw the dbref of the location is included.
+func playerResponse(version int, player *MushPlayer, raw bool) any {
+	if version == API_V1 {
+		return playerV1(player, raw)
+	}
+	return playerV2(player, raw)
+}
+
+// statusMeta is everything besides the players which goes into a status
+// response.
+type statusMeta struct {
+	// Total is the number of players before pagination.
+	Total int
+	// Raw includes the dbrefs of the locations.
+	Raw         bool
+	Stale       bool
+	Connection  string
+	LastUpdated time.Time
+	Server      string
+	ServerInfo  *ServerInfo
+}
+
+// statusResponse converts the players to the status schema of the given
+// API version.
+func statusResponse(version int, players []*MushPlayer, meta statusMeta) any {
+	if version == API_V1 {
+		status := StatusV1{Players: make([]PlayerV1, 0, len(players))}
+		for _, player := range players {
+			if player != nil {
+				status.Players = append(status.Players, playerV1(player, meta.Raw))
+			}
+		}
+		return status
+	}
+	status := StatusV2{
+		Players:    make([]PlayerV2, 0, len(players)),
+		Total:      meta.Total,
+		Stale:      meta.Stale,
+		Connection: meta.Connection,
+		Server:     meta.Server,
+		ServerInfo: meta.ServerInfo,
+	}
+	if !meta.LastUpdated.IsZero() {
+		status.LastUpdated = &meta.LastUpdated
+	}
+	for _, player := range players {
+		if player != nil {
+			status.Players = append(status.Players, playerV2(player, meta.Raw))
+		}
+	}
+	return status
+}
+
+// GroupedStatus is the ?groupBy=location view of the status, which is the
+// same in every API version.
+type GroupedStatus struct {
+	// Locations maps the location names, or dbrefs while they are unknown,
+	// to the names of the players there.
+	Locations map[string][]string `json:"locations"`
+	Stale     bool                `json:"stale"`
+}
+
+// groupByLocation groups the names of the players by location, each group
+// sorted by name.
+func groupByLocation(players []*MushPlayer, stale bool) GroupedStatus {
+	status := GroupedStatus{Locations: make(map[string][]string), Stale: stale}
+	for _, player := range sortPlayers(players, compareNames) {
+		name := player.LocationName()
+		status.Locations[name] = append(status.Locations[name], player.Name)
+	}
+	return status
+}
@@ -0,0 +1,80 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got to testdata/name, or with -update writes it
+// there.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s changed, got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// TestAPIV1Golden pins the frozen v1 schema. If this fails, the change
+// belongs in v2.
+func TestAPIV1Golden(t *testing.T) {
+	s := newTestState(t)
+	s.locations.Set("#10", "Town Square")
+	setPlayers(s,
+		&MushPlayer{Name: "Walker", Location: "#10", Doing: "Fishing"},
+		&MushPlayer{Name: "Rhee", Location: "#11"},
+		&MushPlayer{Name: "Guest"},
+	)
+	handler := s.handler(s.routes())
+	for _, tc := range []struct {
+		path   string
+		golden string
+	}{
+		{"/api/v1?allowStale=1", "api_v1.golden.json"},
+		{"/api?allowStale=1", "api_v1.golden.json"},
+		// Stale data has a different status, but the same body.
+		{"/api/v1", "api_v1.golden.json"},
+		{"/api/v1?allowStale=1&raw=1", "api_v1_raw.golden.json"},
+		{"/api.xml?allowStale=1", "api_v1.golden.xml"},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if recorder.Code != http.StatusOK && recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s answered %d", tc.path, recorder.Code)
+		}
+		checkGolden(t, tc.golden, recorder.Body.Bytes())
+	}
+}
@@ -0,0 +1,423 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// testConfig returns the config main would parse from args, with the
+// required flags filled in for a MUSH nobody dials.
+func testConfig(t testing.TB, args ...string) ServerConfig {
+	t.Helper()
+	var config struct {
+		Server ServerConfig `group:"Server config"`
+	}
+	required := []string{"--address", "127.0.0.1:0", "--host", "127.0.0.1:1", "--connect-command", "connect bot secret"}
+	if _, err := flags.ParseArgs(&config, append(required, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return config.Server
+}
+
+// newTestState sets up the state machine of a single game without starting
+// any of its workers.
+func newTestState(t testing.TB, args ...string) *ServerState {
+	t.Helper()
+	config := testConfig(t, args...)
+	if config.StaleAfter == 0 {
+		config.StaleAfter = 4 * config.PollInterval
+	}
+	s, err := newServerState(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// setPlayers replaces the player list as a WHO would and publishes it.
+func setPlayers(s *ServerState, players ...*MushPlayer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, player := range players {
+		player.locations = s.locations
+	}
+	s.mushState = &MushState{Players: players}
+	s.publishState()
+}
+
+// setLoggedIn puts the state machine in the idle state right after a WHO,
+// so its players aren't stale.
+func setLoggedIn(s *ServerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentState = STATE_IDLE
+	s.lastSuccessfulWho = s.now()
+}
+
+// serve runs request through handler and returns the response.
+func serve(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// testServer is runServer against a fake MUSH, serving HTTP on a loopback
+// port.
+type testServer struct {
+	t     *testing.T
+	url   string
+	state *ServerState
+	stop  func() error
+}
+
+// startServer runs the server until the test ends. env is filled in with
+// the listener and, unless given, the fake MUSH as the dialer.
+func startServer(t *testing.T, mush *fakeMUSH, env serverEnv, args ...string) *testServer {
+	t.Helper()
+	defaults := []string{"--host", "mush.example:4201", "--poll-interval", "1s", "--reconnect-delay", "10ms", "--shutdown-timeout", "1s"}
+	config := testConfig(t, append(defaults, args...)...)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.listener = listener
+	if env.dialer == nil {
+		env.dialer = mush
+	}
+	ready := make(chan *ServerState, 1)
+	env.ready = func(s *ServerState, handler http.Handler) {
+		ready <- s
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(config, ctx, env)
+	}()
+	ts := &testServer{t: t, url: "http://" + listener.Addr().String()}
+	select {
+	case ts.state = <-ready:
+	case err := <-done:
+		cancel()
+		t.Fatalf("server exited: %v", err)
+	}
+	// stop shuts the server down as a signal would and returns what
+	// runServer returned.
+	var stopped error
+	var once sync.Once
+	ts.stop = func() error {
+		once.Do(func() {
+			// The client may have dialed a connection it then didn't
+			// need, which Shutdown waits for as if a request was about
+			// to come.
+			http.DefaultClient.CloseIdleConnections()
+			cancel()
+			stopped = <-done
+		})
+		return stopped
+	}
+	t.Cleanup(func() {
+		if err := ts.stop(); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	})
+	return ts
+}
+
+// get decodes the JSON response to a GET of path into v and returns the
+// status.
+func (ts *testServer) get(path string, v any) int {
+	ts.t.Helper()
+	response, err := http.Get(ts.url + path)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			ts.t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return response.StatusCode
+}
+
+func (ts *testServer) currentState() string {
+	ts.state.mu.RLock()
+	defer ts.state.mu.RUnlock()
+	return ts.state.currentState
+}
+
+// waitForPlayers waits until the v1 API lists the players of who.txt with
+// their rooms resolved.
+func (ts *testServer) waitForPlayers() StatusV1 {
+	ts.t.Helper()
+	var status StatusV1
+	waitFor(ts.t, "the players of who.txt", func() bool {
+		status = StatusV1{}
+		ts.get("/api/v1", &status)
+		return len(status.Players) == 3 && status.Players[1].Location == "The Docks"
+	})
+	return status
+}
+
+func TestLoginAndWho(t *testing.T) {
+	mush := newFakeMUSH(t)
+	ts := startServer(t, mush, serverEnv{})
+
+	mush.Expect("connect bot secret")
+	mush.Expect("who")
+	status := ts.waitForPlayers()
+	want := []PlayerV1{
+		{Name: "Rhee", Location: "Town Square"},
+		{Name: "Sam", Location: "The Docks"},
+		{Name: "Walker", Location: "Town Square"},
+	}
+	for i, player := range want {
+		if status.Players[i] != player {
+			t.Errorf("player %d is %+v, want %+v", i, status.Players[i], player)
+		}
+	}
+	if state := ts.currentState(); state != STATE_IDLE {
+		t.Errorf("state is %s, want %s", state, STATE_IDLE)
+	}
+	var health HealthStatus
+	if code := ts.get("/healthz", &health); code != http.StatusOK || !health.Healthy {
+		t.Errorf("healthz answered %d %+v", code, health)
+	}
+}
+
+func TestStaleOnInjectedClock(t *testing.T) {
+	mush := newFakeMUSH(t)
+	clock := newFakeClock()
+	ts := startServer(t, mush, serverEnv{now: clock.Now}, "--stale-after", "1m", "--poll-interval", "1h")
+	ts.waitForPlayers()
+
+	var status StatusV2
+	if code := ts.get("/api/v2", &status); code != http.StatusOK || status.Stale {
+		t.Fatalf("fresh status answered %d, stale %t", code, status.Stale)
+	}
+	if status.LastUpdated == nil || !status.LastUpdated.Equal(clock.Now()) {
+		t.Errorf("lastUpdated is %v, want the fake clock's %v", status.LastUpdated, clock.Now())
+	}
+
+	// The next WHO isn't due on the real clock for an hour.
+	clock.Advance(2 * time.Minute)
+	status = StatusV2{}
+	if code := ts.get("/api/v2", &status); code != http.StatusServiceUnavailable || !status.Stale {
+		t.Errorf("status after --stale-after answered %d, stale %t", code, status.Stale)
+	}
+}
+
+func TestReconnectAfterConnectionLoss(t *testing.T) {
+	mush := newFakeMUSH(t)
+	ts := startServer(t, mush, serverEnv{tick: 50 * time.Millisecond})
+	ts.waitForPlayers()
+
+	mush.Forget()
+	mush.Kick()
+	waitFor(t, "a second login", func() bool {
+		dials, logins := mush.Counts()
+		return dials == 2 && logins == 2
+	})
+	mush.Expect("who")
+	waitFor(t, "the state to be idle again", func() bool {
+		return ts.currentState() == STATE_IDLE
+	})
+	var stats Stats
+	ts.get("/api/stats", &stats)
+	if stats.ConnectionErrors != 1 {
+		t.Errorf("%d connection errors, want 1", stats.ConnectionErrors)
+	}
+}
+
+func TestLoginAgainAfterRestart(t *testing.T) {
+	mush := newFakeMUSH(t)
+	ts := startServer(t, mush, serverEnv{})
+	ts.waitForPlayers()
+
+	mush.Forget()
+	// The MUSH comes back on the same connection with its login screen.
+	mush.Announce("GAME: Restart by Wizard.\r\n" + readFixture(t, "banner.txt"))
+	mush.Expect("connect bot secret")
+	waitFor(t, "the login after the restart", func() bool {
+		_, logins := mush.Counts()
+		return logins == 2 && ts.currentState() == STATE_IDLE
+	})
+	if dials, _ := mush.Counts(); dials != 1 {
+		t.Errorf("dialed %d times, want once", dials)
+	}
+	var stats Stats
+	ts.get("/api/stats", &stats)
+	if stats.Restarts != 1 {
+		t.Errorf("%d restarts, want 1", stats.Restarts)
+	}
+	ts.waitForPlayers()
+}
+
+func TestETag(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker", Location: "#10"})
+	handler := s.handler(s.routes())
+
+	first := serve(handler, httptest.NewRequest(http.MethodGet, "/api", nil))
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("first request: %d, ETag %q, %d bytes", first.Code, etag, first.Body.Len())
+	}
+
+	for _, tc := range []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/api", nil)
+		request.Header.Set("If-None-Match", tc.ifNoneMatch)
+		response := serve(handler, request)
+		if response.Code != tc.want {
+			t.Errorf("If-None-Match %s: got %d, want %d", tc.ifNoneMatch, response.Code, tc.want)
+		}
+		if tc.want == http.StatusNotModified && response.Body.Len() > 0 {
+			t.Errorf("If-None-Match %s: 304 with a body", tc.ifNoneMatch)
+		}
+	}
+
+	// The tag seen before the change doesn't match the new players.
+	setPlayers(s, &MushPlayer{Name: "Walker", Location: "#10"}, &MushPlayer{Name: "Rhee", Location: "#10"})
+	request := httptest.NewRequest(http.MethodGet, "/api", nil)
+	request.Header.Set("If-None-Match", etag)
+	response := serve(handler, request)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") == etag {
+		t.Errorf("after a change: %d with ETag %s", response.Code, response.Header().Get("ETag"))
+	}
+	var status StatusV1
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil || len(status.Players) != 2 {
+		t.Errorf("after a change: %s", response.Body)
+	}
+}
+
+func TestJSONPCallback(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker", Location: "#10"})
+	handler := s.handler(s.routes())
+	plain := serve(handler, httptest.NewRequest(http.MethodGet, "/api", nil))
+
+	for _, tc := range []struct {
+		callback    string
+		status      int
+		contentType string
+	}{
+		{"", http.StatusOK, "application/json; charset=utf-8"},
+		{"myFunc", http.StatusOK, "application/javascript"},
+		{"jQuery.cb_2", http.StatusOK, "application/javascript"},
+		{"$", http.StatusOK, "application/javascript"},
+		{"alert(1)//", http.StatusBadRequest, ""},
+		{"a b", http.StatusBadRequest, ""},
+		{"9lives", http.StatusBadRequest, ""},
+		{"a..b", http.StatusBadRequest, ""},
+		{"<script>", http.StatusBadRequest, ""},
+		{strings.Repeat("a", 65), http.StatusBadRequest, ""},
+	} {
+		path := "/api"
+		if tc.callback != "" {
+			path += "?callback=" + url.QueryEscape(tc.callback)
+		}
+		response := serve(handler, httptest.NewRequest(http.MethodGet, path, nil))
+		if response.Code != tc.status {
+			t.Errorf("callback %q: got %d, want %d", tc.callback, response.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			if strings.Contains(response.Body.String(), tc.callback) {
+				t.Errorf("callback %q is reflected: %s", tc.callback, response.Body)
+			}
+			continue
+		}
+		if got := response.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("callback %q: Content-Type %s", tc.callback, got)
+		}
+		want := plain.Body.String()
+		if tc.callback != "" {
+			want = tc.callback + "(" + want + ");"
+		}
+		if response.Body.String() != want {
+			t.Errorf("callback %q: got %s, want %s", tc.callback, response.Body, want)
+		}
+	}
+}
+
+func TestOrderlyShutdown(t *testing.T) {
+	mush := newFakeMUSH(t)
+	mush.SetWho("who.txt")
+	ts := startServer(t, mush, serverEnv{})
+	ts.waitForPlayers()
+
+	// A stream which is still open when the signal comes.
+	response, err := http.Get(ts.url + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	stream := bufio.NewReader(response.Body)
+	if line, err := stream.ReadString('\n'); err != nil || line != "event: snapshot\n" {
+		t.Fatalf("first line of the stream %q, %v", line, err)
+	}
+
+	mush.Forget()
+	if err := ts.stop(); err != nil {
+		t.Fatalf("runServer returned %v", err)
+	}
+	mush.Expect("QUIT")
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Errorf("the stream wasn't closed cleanly: %v", err)
+	}
+	if _, err := http.Get(ts.url + "/healthz"); err == nil {
+		t.Error("still serving after shutdown")
+	}
+	// The workers are gone, so nothing is sent anymore.
+	mush.Forget()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case line := <-mush.lines:
+		t.Errorf("sent %q after shutdown", line)
+	default:
+	}
+	if state := ts.currentState(); state != STATE_NOT_CONNECTED && state != STATE_QUITTING {
+		t.Errorf("state %s after shutdown", state)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// setupWait is how long a setup command is treated as in flight. Many have
+// no output at all, so nothing arriving in that time is fine.
+const setupWait = 2 * time.Second
+
+// queueSetup queues the --setup-command commands right after logging in,
+// so they run in order before the first WHO, followed by the @doing. The
+// caller holds s.mu.
+func (s *ServerState) queueSetup() {
+	commands := append([]string{}, s.config.SetupCmds...)
+	if s.config.Doing != "" {
+		commands = append(commands, "@doing "+s.doingText())
+	}
+	for _, command := range commands {
+		s.queue = append(s.queue, Command{Text: command, Expect: STATE_SETUP, Timeout: setupWait})
+	}
+}
+
+// doingText fills in the placeholders of --doing.
+func (s *ServerState) doingText() string {
+	return strings.NewReplacer(
+		"{version}", serverVersion(),
+		"{address}", s.config.Address,
+	).Replace(s.config.Doing)
+}
+
+// serverVersion is the version of the module, or for a build from a
+// checkout the commit, as far as the binary knows.
+func serverVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return setting.Value[:12]
+		}
+	}
+	return "unknown"
+}
+
+// processSetup handles the response to a setup command. One which fails is
+// only logged, since the WHO may well be fine without it. The caller holds
+// s.mu.
+func (s *ServerState) processSetup(message string) {
+	if s.outputPrefix != "" {
+		if _, output, ok := strings.Cut(message, s.outputPrefix); ok {
+			message = strings.TrimLeft(output, "\r\n")
+		}
+	}
+	if s.isErrorReply(message) {
+		reply, _, _ := strings.Cut(message, "\n")
+		reply = strings.TrimSpace(reply)
+		s.logger.Printf("Setup command %q failed: %s", s.inFlight.Text, reply)
+		s.recordError("setup command failed: " + reply)
+	}
+	s.setState(STATE_IDLE)
+	s.dispatch()
+}
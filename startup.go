@@ -0,0 +1,70 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The startup checks catch a MUSH which can't be reached at all, e.g.
+// because of a typo in --host, by exiting instead of retrying forever, so a
+// supervisor like systemd notices. They end with the first login.
+
+// giveUpStartup hands initServer an error if --startup-max-attempts
+// attempts failed without ever logging in, and reports whether it did. The
+// caller holds s.mu.
+func (s *ServerState) giveUpStartup() bool {
+	if s.loggedInOnce || s.config.StartupTries <= 0 || s.startupTries < s.config.StartupTries {
+		return false
+	}
+	s.failStartup(fmt.Errorf("could not log in to %s in %d attempts%s", s.config.TelnetHost, s.startupTries, s.lastErrorSuffix()))
+	return true
+}
+
+// watchStartup fails the startup if any game wasn't logged in to once
+// --startup-connect-timeout has passed.
+func watchStartup(games []*ServerState, timeout time.Duration) *time.Timer {
+	return time.AfterFunc(timeout, func() {
+		for _, s := range games {
+			s.mu.RLock()
+			if !s.loggedInOnce {
+				s.failStartup(fmt.Errorf("could not log in to %s within %s%s", s.config.TelnetHost, timeout, s.lastErrorSuffix()))
+			}
+			s.mu.RUnlock()
+		}
+	})
+}
+
+func (s *ServerState) failStartup(err error) {
+	if s.name != "" {
+		err = fmt.Errorf("game %s: %w", s.name, err)
+	}
+	select {
+	case s.startupFailed <- err:
+	default:
+	}
+}
+
+// lastErrorSuffix explains a failed startup with what went wrong last.
+func (s *ServerState) lastErrorSuffix() string {
+	if s.lastError == nil {
+		return ""
+	}
+	return ": " + s.lastError.Message
+}
@@ -0,0 +1,85 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type Stats struct {
+	MetricsSnapshot
+	StartedAt             time.Time  `json:"startedAt"`
+	UptimeSeconds         float64    `json:"uptimeSeconds"`
+	State                 string     `json:"state"`
+	SessionStart          *time.Time `json:"sessionStart"`
+	SessionUptimeSeconds  float64    `json:"sessionUptimeSeconds"`
+	LastSuccessfulWho     *time.Time `json:"lastSuccessfulWho"`
+	FailedWhosInRow       int        `json:"consecutiveWhoFailures"`
+	LastReceived          *time.Time `json:"lastReceived"`
+	NextReconnect         *time.Time `json:"nextReconnect"`
+	LocationCacheSize     int        `json:"locationCacheSize"`
+	UnknownLocationsQueue int        `json:"unknownLocationsQueue"`
+	PendingLookups        int        `json:"pendingLookups"`
+	QueuedCommands        int        `json:"queuedCommands"`
+}
+
+func (s *ServerState) stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	stats := Stats{
+		MetricsSnapshot: s.metrics.Snapshot(),
+		StartedAt:       s.startedAt,
+		UptimeSeconds:   now.Sub(s.startedAt).Seconds(),
+		State:           s.currentState,
+	}
+	if !s.sessionStart.IsZero() {
+		sessionStart := s.sessionStart
+		stats.SessionStart = &sessionStart
+		stats.SessionUptimeSeconds = now.Sub(sessionStart).Seconds()
+	}
+	if !s.lastSuccessfulWho.IsZero() {
+		lastWho := s.lastSuccessfulWho
+		stats.LastSuccessfulWho = &lastWho
+	}
+	if !s.lastReceived.IsZero() {
+		lastReceived := s.lastReceived
+		stats.LastReceived = &lastReceived
+	}
+	if !s.nextReconnect.IsZero() {
+		nextReconnect := s.nextReconnect
+		stats.NextReconnect = &nextReconnect
+	}
+	stats.LocationCacheSize, stats.UnknownLocationsQueue = s.locations.Size()
+	stats.PendingLookups = s.queuedLookups()
+	stats.QueuedCommands = len(s.queue)
+	stats.FailedWhosInRow = len(s.whoFailures)
+	return stats
+}
+
+func (s *ServerState) serveStats(w http.ResponseWriter, r *http.Request) {
+	jsonBody, err := json.Marshal(s.stats())
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBody)
+}
@@ -0,0 +1,169 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// statusRequest is what a request to the status endpoints asks for.
+type statusRequest struct {
+	ContentType string
+	Locations   []string
+	NamePrefix  string
+	Order       playerOrder
+	Limit       int
+	Offset      int
+	GroupBy     string
+	Pretty      bool
+	Raw         bool
+}
+
+// defaultStatusRequest is a status request without any parameters.
+var defaultStatusRequest = statusRequest{
+	ContentType: "application/json",
+	Order:       compareNames,
+	Limit:       -1,
+}
+
+// RenderedStatus is a serialized status response along with what goes into
+// its headers.
+type RenderedStatus struct {
+	Body         []byte
+	ContentType  string
+	ETag         string
+	Revision     uint64
+	Total        int
+	LastModified time.Time
+	Stale        bool
+}
+
+// StatusCache holds the default response of every API version as of the
+// last change of the state machine, and what it needs to tell whether that
+// response has become stale since.
+type StatusCache struct {
+	responses map[int]*RenderedStatus
+	state     string
+	lastWho   time.Time
+}
+
+// renderStatus serializes the status for a request. The caller holds s.mu.
+func (s *ServerState) renderStatus(version int, request statusRequest) (*RenderedStatus, error) {
+	players := s.mushState.Players
+	if len(request.Locations) > 0 {
+		players = filterByLocation(players, request.Locations)
+	}
+	if request.NamePrefix != "" {
+		players = filterByNamePrefix(players, request.NamePrefix)
+	}
+	total := len(players)
+	players = paginate(sortPlayers(players, request.Order), request.Limit, request.Offset)
+	stale := s.isStale()
+
+	var status any
+	if request.GroupBy != "" {
+		status = groupByLocation(players, stale)
+	} else {
+		meta := statusMeta{
+			Total:       total,
+			Raw:         request.Raw,
+			Stale:       stale,
+			Connection:  connectionName(s.currentState),
+			LastUpdated: s.lastSuccessfulWho,
+		}
+		if !s.config.RedactServer {
+			meta.Server = s.config.TelnetHost
+		}
+		meta.ServerInfo = s.serverInfo()
+		status = statusResponse(version, players, meta)
+	}
+	var body []byte
+	var err error
+	switch {
+	case request.ContentType == "text/plain":
+		body = formatPlayerTable(players)
+	case request.ContentType == "application/xml":
+		body, err = formatXML(status, request.Pretty)
+	case request.Pretty:
+		body, err = json.MarshalIndent(status, "", "  ")
+		body = append(body, '\n')
+	default:
+		body, err = json.Marshal(status)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The revision only changes with the players. Staleness and the v2
+	// metadata change without it.
+	tag := strconv.FormatUint(s.revision, 10)
+	if version != API_V1 {
+		tag += fmt.Sprintf("-%d-%s", s.lastSuccessfulWho.Unix(), connectionName(s.currentState))
+	}
+	if stale {
+		tag += "-stale"
+	}
+	return &RenderedStatus{
+		Body:         body,
+		ContentType:  request.ContentType,
+		ETag:         `"` + tag + `"`,
+		Revision:     s.revision,
+		Total:        total,
+		LastModified: s.lastChanged,
+		Stale:        stale,
+	}, nil
+}
+
+// cacheStatus serializes the default responses after the state changed.
+// The caller holds s.mu for writing.
+func (s *ServerState) cacheStatus() {
+	if s.mushState == nil {
+		return
+	}
+	cache := &StatusCache{
+		responses: make(map[int]*RenderedStatus),
+		state:     s.currentState,
+		lastWho:   s.lastSuccessfulWho,
+	}
+	for _, version := range []int{API_V1, API_V2} {
+		rendered, err := s.renderStatus(version, defaultStatusRequest)
+		if err != nil {
+			s.logger.Println("Could not serialize status:", err)
+			continue
+		}
+		cache.responses[version] = rendered
+	}
+	s.statusCache.Store(cache)
+}
+
+// cachedStatus returns the cached default response of an API version, or
+// nil if there is none or it has turned stale since it was cached.
+func (s *ServerState) cachedStatus(version int) *RenderedStatus {
+	cache := s.statusCache.Load()
+	if cache == nil {
+		return nil
+	}
+	rendered := cache.responses[version]
+	if rendered == nil || rendered.Stale != isStale(cache.state, cache.lastWho, s.config.StaleAfter, s.now()) {
+		return nil
+	}
+	return rendered
+}
@@ -0,0 +1,115 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// benchmarkStatus serves path against a hundred players.
+func benchmarkStatus(b *testing.B, path string) {
+	s := newTestState(b, "--stale-after", "1h")
+	setLoggedIn(s)
+	for i := 0; i < 10; i++ {
+		s.locations.Set(fmt.Sprintf("#%d", i), fmt.Sprintf("Room %d", i))
+	}
+	players := manyPlayers(100)
+	for i, player := range players {
+		player.Location = MushLocation(fmt.Sprintf("#%d", i%10))
+	}
+	setPlayers(s, players...)
+	handler := s.handler(s.routes())
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		serve(handler, request)
+	}
+}
+
+// BenchmarkStatusCached is what most pollers get.
+func BenchmarkStatusCached(b *testing.B) {
+	benchmarkStatus(b, "/api")
+}
+
+// BenchmarkStatusRendered is the same response serialized for the request,
+// as before the cache.
+func BenchmarkStatusRendered(b *testing.B) {
+	benchmarkStatus(b, "/api?sort=name")
+}
+
+// TestStatusCacheRace serves the status while WHOs replace the players. Run
+// it with -race.
+func TestStatusCacheRace(t *testing.T) {
+	s := newTestState(t)
+	setLoggedIn(s)
+	setPlayers(s, manyPlayers(1)...)
+	handler := s.handler(s.routes())
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 2; i <= 50; i++ {
+			setPlayers(s, manyPlayers(i)...)
+		}
+	}()
+	for _, path := range []string{"/api", "/api/v2", "/api?sort=name", "/api.xml"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				response := serve(handler, httptest.NewRequest(http.MethodGet, path, nil))
+				if response.Code != http.StatusOK {
+					t.Errorf("%s answered %d", path, response.Code)
+					return
+				}
+				if path == "/api.xml" {
+					continue
+				}
+				// The count and the players come from the same state.
+				var status StatusV2
+				if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+					t.Errorf("%s: %v", path, err)
+					return
+				}
+				if path == "/api/v2" && status.Total != len(status.Players) {
+					t.Errorf("%s: total %d with %d players", path, status.Total, len(status.Players))
+					return
+				}
+				if total := response.Header().Get("X-Total-Count"); total != fmt.Sprint(len(status.Players)) {
+					t.Errorf("%s: X-Total-Count %s with %d players", path, total, len(status.Players))
+					return
+				}
+			}
+		}(path)
+	}
+	wg.Wait()
+}
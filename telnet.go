@@ -20,11 +20,14 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"log"
+	"net"
 	"time"
 
 	"github.com/reiver/go-oi"
 	"github.com/reiver/go-telnet"
+	"golang.org/x/net/proxy"
 )
 
 type TelnetCaller struct {
@@ -32,31 +35,107 @@ type TelnetCaller struct {
 	Output   chan string
 	ErrorOut chan string
 	ErrorIn  chan error
+	// EndOfRecord is sent, after the text before it, whenever the MUSH
+	// marks the end of a response with GA or EOR.
+	EndOfRecord chan struct{}
+	// Charset converts the text in both directions, the channels carry
+	// UTF-8.
+	Charset *Charset
+	// LineEnding is written after every command.
+	LineEnding string
+}
+
+// lineEndings maps the choices of --line-ending to what they send.
+var lineEndings = map[string]string{
+	"crlf": "\r\n",
+	"lf":   "\n",
+	"cr":   "\r",
+}
+
+// dialTelnet connects to the MUSH through dialer, over TLS if tlsConfig is
+// given. The host name is resolved again on every call, so a MUSH behind a
+// dynamic DNS name is found at its new address. timeout, unless 0, also
+// bounds the TLS handshake, so a MUSH which accepts and never answers
+// doesn't hold up the reconnect forever.
+func dialTelnet(address string, dialer proxy.Dialer, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	enableKeepalive(conn)
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" {
+			// Like tls.Dial, verify the certificate against the host.
+			host, _, _ := net.SplitHostPort(address)
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if timeout > 0 {
+			conn.SetDeadline(time.Now().Add(timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, &HandshakeError{Err: err}
+		}
+		conn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// runTelnet runs caller on the connection until it returns. go-telnet's own
+// connection is left out because it treats a command such as GA as corrupt
+// data and can't answer negotiation.
+func runTelnet(conn net.Conn, caller TelnetCaller, options *TelnetOptions) {
+	telnetConn := NewTelnetConn(conn, options)
+	defer telnetConn.Close()
+	caller.CallTELNET(telnet.NewContext(), telnetConn, telnetConn)
 }
 
 func (caller TelnetCaller) CallTELNET(ctx telnet.Context, writer telnet.Writer, reader telnet.Reader) {
+	// done stops the reading and writing goroutines once the session is
+	// over, so they don't block forever on channels nobody serves.
+	done := make(chan struct{})
+	defer close(done)
+
+	// The read error comes after the last chunk, so that the text the MUSH
+	// sent right before closing, like a disconnect message, is delivered
+	// before the error. A write error ends the session the same way.
+	readErr := make(chan string, 1)
 
 	// Send text to MUD
 	go func() {
 		var buffer bytes.Buffer
 		var p []byte
 
-		crlfBuffer := [2]byte{'\r', '\n'}
-		crlf := crlfBuffer[:]
-
 		for {
-			message := <-caller.Input
-			buffer.Write([]byte(message))
-			buffer.Write(crlf)
+			var message string
+			select {
+			case message = <-caller.Input:
+			case <-done:
+				return
+			}
+			buffer.WriteString(caller.Charset.Encode(message))
+			buffer.WriteString(caller.LineEnding)
 
 			p = buffer.Bytes()
-			oi.LongWrite(writer, p)
+			if _, err := oi.LongWrite(writer, p); err != nil {
+				// The session ends like on a read error, which also
+				// unblocks the reader once the connection is closed.
+				select {
+				case readErr <- err.Error():
+				default:
+				}
+				return
+			}
 			buffer.Reset()
 		}
 	}()
 
 	// Receive text from MUD
 	chunks := make(chan string)
+	records := make(chan struct{})
 	chunk := ""
 
 	go func() {
@@ -65,19 +144,65 @@ func (caller TelnetCaller) CallTELNET(ctx telnet.Context, writer telnet.Writer,
 
 		for {
 			n, err := reader.Read(p)
+			if err == errEndOfRecord {
+				// Both are sent from here, so the record can't overtake
+				// the text which ended it.
+				if n > 0 {
+					select {
+					case chunks <- string(p[:n]):
+					case <-done:
+						return
+					}
+				}
+				select {
+				case records <- struct{}{}:
+				case <-done:
+					return
+				}
+				continue
+			}
 			if n <= 0 && err == nil {
 				continue
 			} else if err != nil {
-				caller.ErrorOut <- err.Error()
+				select {
+				case readErr <- err.Error():
+				default:
+				}
 				return
 			} else if n <= 0 {
 				break
 			}
 
-			chunks <- string(p)
+			select {
+			case chunks <- string(p):
+			case <-done:
+				return
+			}
 		}
 	}()
 
+	// deliver hands the chunk to the caller, reporting false if the session
+	// was closed instead.
+	deliver := func() bool {
+		select {
+		case caller.Output <- caller.Charset.Decode(chunk):
+			chunk = ""
+			return true
+		case err := <-caller.ErrorIn:
+			log.Default().Println("closing telnet:", err)
+			return false
+		}
+	}
+	endRecord := func() bool {
+		select {
+		case caller.EndOfRecord <- struct{}{}:
+			return true
+		case err := <-caller.ErrorIn:
+			log.Default().Println("closing telnet:", err)
+			return false
+		}
+	}
+
 	for {
 		select {
 		case input := <-chunks:
@@ -86,11 +211,27 @@ func (caller TelnetCaller) CallTELNET(ctx telnet.Context, writer telnet.Writer,
 				select {
 				case input := <-chunks:
 					chunk = chunk + input
+				case <-records:
+					if !deliver() || !endRecord() {
+						return
+					}
 				case <-time.After(time.Millisecond * 500):
-					caller.Output <- chunk
-					chunk = ""
+					if !deliver() {
+						return
+					}
 				}
 			}
+		case <-records:
+			if !endRecord() {
+				return
+			}
+		case err := <-readErr:
+			select {
+			case caller.ErrorOut <- err:
+			case err := <-caller.ErrorIn:
+				log.Default().Println("closing telnet:", err)
+				return
+			}
 		case err := <-caller.ErrorIn:
 			log.Default().Println("closing telnet:", err)
 			return
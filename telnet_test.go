@@ -0,0 +1,131 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// callerRecords runs a TelnetCaller against a MUSH which sends stream, and
+// returns the text it delivered, split where it signalled the end of a
+// record, once until has passed. framed tells whether the last record
+// was ended.
+func callerRecords(t *testing.T, stream []byte, until time.Duration) (records []string, framed bool, took time.Duration) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(stream)
+		// Hold the connection open, so only the markers and the quiet
+		// period end the records.
+		buffer := make([]byte, 64)
+		for {
+			if _, err := conn.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caller := TelnetCaller{
+		Input:       make(chan string),
+		Output:      make(chan string),
+		ErrorOut:    make(chan string),
+		ErrorIn:     make(chan error),
+		EndOfRecord: make(chan struct{}),
+		LineEnding:  "\r\n",
+	}
+	done := make(chan struct{})
+	go func() {
+		runTelnet(conn, caller, &TelnetOptions{})
+		close(done)
+	}()
+
+	start := time.Now()
+	current := ""
+	timeout := time.After(until)
+	for {
+		select {
+		case text := <-caller.Output:
+			current += text
+			framed = false
+		case <-caller.EndOfRecord:
+			records = append(records, current)
+			current = ""
+			framed = true
+			took = time.Since(start)
+		case err := <-caller.ErrorOut:
+			t.Fatalf("read error %s", err)
+		case <-timeout:
+			if current != "" {
+				records = append(records, current)
+			}
+			caller.ErrorIn <- errors.New("test over")
+			<-done
+			return records, framed, took
+		}
+	}
+}
+
+func readStream(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEndOfRecordFraming(t *testing.T) {
+	want := []string{readFixture(t, "banner.txt"), readFixture(t, "who.txt"), "You say, \"#10\"Town Square\"\r\n"}
+	for _, fixture := range []string{"stream_ga.bin", "stream_eor.bin"} {
+		records, framed, took := callerRecords(t, readStream(t, fixture), time.Second)
+		if !slices.Equal(records, want) || !framed {
+			t.Errorf("%s: got records %q, framed %v, want %q", fixture, records, framed, want)
+		}
+		// The markers end the records right away, without the quiet period.
+		if took >= 500*time.Millisecond {
+			t.Errorf("%s: the records took %v", fixture, took)
+		}
+	}
+}
+
+func TestQuietFramingWithoutMarkers(t *testing.T) {
+	who := readFixture(t, "who.txt")
+	records, framed, _ := callerRecords(t, []byte(who), time.Second)
+	if !slices.Equal(records, []string{who}) || framed {
+		t.Errorf("got records %q, framed %v", records, framed)
+	}
+}
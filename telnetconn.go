@@ -0,0 +1,277 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// telnetWriteTimeout is how long a write to the MUSH may take. A MUSH which
+// stopped reading fills the socket buffers, and the write would block
+// forever.
+const telnetWriteTimeout = 10 * time.Second
+
+// Telnet commands, see RFC 854.
+const (
+	TELNET_EOR  = 239
+	TELNET_SE   = 240
+	TELNET_GA   = 249
+	TELNET_SB   = 250
+	TELNET_WILL = 251
+	TELNET_WONT = 252
+	TELNET_DO   = 253
+	TELNET_DONT = 254
+	TELNET_IAC  = 255
+)
+
+// Telnet options we agree to. END-OF-RECORD is described in RFC 885, MSSP
+// at https://tintin.mudhalla.net/protocols/mssp/.
+const (
+	TELNET_OPT_EOR  = 25
+	TELNET_OPT_MSSP = 70
+)
+
+// telnetAccepted are the options we agree to when the MUSH offers them.
+var telnetAccepted = map[byte]bool{
+	TELNET_OPT_EOR:  true,
+	TELNET_OPT_MSSP: true,
+}
+
+// errEndOfRecord is returned by Read for a GA or EOR, which a MUSH sends
+// once it's done with a response. The text read before it is returned too.
+var errEndOfRecord = errors.New("end of record")
+
+var telnetVerbs = map[byte]string{
+	TELNET_WILL: "WILL",
+	TELNET_WONT: "WONT",
+	TELNET_DO:   "DO",
+	TELNET_DONT: "DONT",
+}
+
+var telnetOptionNames = map[byte]string{
+	1:   "ECHO",
+	3:   "SUPPRESS-GO-AHEAD",
+	24:  "TERMINAL-TYPE",
+	25:  "END-OF-RECORD",
+	31:  "NAWS",
+	34:  "LINEMODE",
+	39:  "NEW-ENVIRON",
+	42:  "CHARSET",
+	69:  "MSDP",
+	70:  "MSSP",
+	86:  "MCCP2",
+	91:  "MXP",
+	201: "GMCP",
+}
+
+func telnetOptionName(option byte) string {
+	if name, ok := telnetOptionNames[option]; ok {
+		return name
+	}
+	return fmt.Sprint(option)
+}
+
+// TelnetOptions records the options the MUSH asked for during a session,
+// for /debug/state, and what it reported through MSSP.
+type TelnetOptions struct {
+	mu        sync.Mutex
+	requested []string
+	mssp      []MSSPVariable
+}
+
+func (o *TelnetOptions) Record(command byte, option byte) {
+	request := telnetVerbs[command] + " " + telnetOptionName(option)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, seen := range o.requested {
+		if seen == request {
+			return
+		}
+	}
+	o.requested = append(o.requested, request)
+}
+
+func (o *TelnetOptions) List() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string{}, o.requested...)
+}
+
+func (o *TelnetOptions) SetMSSP(variables []MSSPVariable) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.mssp = variables
+}
+
+// MSSP returns the variables of the last MSSP report, nil if there was
+// none.
+func (o *TelnetOptions) MSSP() []MSSPVariable {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.mssp
+}
+
+// TelnetConn speaks the telnet protocol on a connection. Reading returns
+// the text only: commands are taken out of the stream, wherever they appear,
+// and every option the MUSH offers or asks for is refused, except for
+// END-OF-RECORD and MSSP. Writing escapes IAC bytes in the text.
+type TelnetConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	options *TelnetOptions
+	// enabled holds the options we agreed to, so a repeated offer isn't
+	// answered again.
+	enabled map[byte]bool
+}
+
+func NewTelnetConn(conn net.Conn, options *TelnetOptions) *TelnetConn {
+	return &TelnetConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		options: options,
+		enabled: make(map[byte]bool),
+	}
+}
+
+// Read fills p with text, returning once at least one byte of it was read.
+// It stops early with errEndOfRecord at a GA or EOR.
+func (c *TelnetConn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if n > 0 && c.reader.Buffered() == 0 {
+			break
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != TELNET_IAC {
+			p[n] = b
+			n++
+			continue
+		}
+		command, err := c.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch command {
+		case TELNET_IAC:
+			p[n] = TELNET_IAC
+			n++
+		case TELNET_WILL, TELNET_WONT, TELNET_DO, TELNET_DONT:
+			option, err := c.reader.ReadByte()
+			if err != nil {
+				return n, err
+			}
+			if err := c.negotiate(command, option); err != nil {
+				return n, err
+			}
+		case TELNET_SB:
+			if err := c.subnegotiation(); err != nil {
+				return n, err
+			}
+		case TELNET_GA, TELNET_EOR:
+			return n, errEndOfRecord
+		default:
+			// NOP and the like carry nothing we need.
+		}
+	}
+	return n, nil
+}
+
+// negotiate refuses an option, or accepts END-OF-RECORD, which the MUSH
+// offers to mark the end of its responses, and MSSP, with which it reports
+// about itself. WONT and DONT need no answer, since every other option is
+// off already.
+func (c *TelnetConn) negotiate(command byte, option byte) error {
+	c.options.Record(command, option)
+	var answer byte
+	switch {
+	case command == TELNET_WILL && telnetAccepted[option]:
+		if c.enabled[option] {
+			return nil
+		}
+		c.enabled[option] = true
+		answer = TELNET_DO
+	case command == TELNET_WONT && c.enabled[option]:
+		c.enabled[option] = false
+		answer = TELNET_DONT
+	case command == TELNET_WILL:
+		answer = TELNET_DONT
+	case command == TELNET_DO:
+		answer = TELNET_WONT
+	default:
+		return nil
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(telnetWriteTimeout))
+	_, err := c.conn.Write([]byte{TELNET_IAC, answer, option})
+	return err
+}
+
+// subnegotiation reads up to the IAC SE and keeps an MSSP report. Anything
+// else is dropped.
+func (c *TelnetConn) subnegotiation() error {
+	var data []byte
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != TELNET_IAC {
+			data = append(data, b)
+			continue
+		}
+		b, err = c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case TELNET_SE:
+			if len(data) > 0 && data[0] == TELNET_OPT_MSSP && c.enabled[TELNET_OPT_MSSP] {
+				c.options.SetMSSP(parseMSSP(data[1:]))
+			}
+			return nil
+		case TELNET_IAC:
+			data = append(data, TELNET_IAC)
+		}
+	}
+}
+
+func (c *TelnetConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	escaped := bytes.ReplaceAll(p, []byte{TELNET_IAC}, []byte{TELNET_IAC, TELNET_IAC})
+	c.conn.SetWriteDeadline(time.Now().Add(telnetWriteTimeout))
+	if _, err := c.conn.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *TelnetConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,164 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// telnetRead sends input over a loopback connection to a TelnetConn and
+// returns the text it reads, the end of records in it as "<EOR>", and what
+// it answered.
+func telnetRead(t *testing.T, input []byte) (string, []byte, *TelnetOptions) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	answers := make(chan []byte)
+	go func() {
+		server.Write(input)
+		server.(*net.TCPConn).CloseWrite()
+		answer, _ := io.ReadAll(server)
+		answers <- answer
+	}()
+
+	options := &TelnetOptions{}
+	conn := NewTelnetConn(client, options)
+	var text strings.Builder
+	buffer := make([]byte, 16)
+	for {
+		n, err := conn.Read(buffer)
+		text.Write(buffer[:n])
+		if errors.Is(err, errEndOfRecord) {
+			text.WriteString("<EOR>")
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+	return text.String(), <-answers, options
+}
+
+func TestTelnetConnRead(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		text    string
+		answer  string
+		options []string
+	}{
+		{"plain", "Player Name  On For\r\n", "Player Name  On For\r\n", "", nil},
+		{"WILL mid-line", "Player\xff\xfb\x01 Name\r\n", "Player Name\r\n", "\xff\xfe\x01", []string{"WILL ECHO"}},
+		{"DO mid-word", "Wal\xff\xfd\x18ker\r\n", "Walker\r\n", "\xff\xfc\x18", []string{"DO TERMINAL-TYPE"}},
+		{"WONT and DONT", "a\xff\xfc\x01b\xff\xfe\x1fc", "abc", "", []string{"WONT ECHO", "DONT NAWS"}},
+		{"accepted once", "\xff\xfb\x19x\xff\xfb\x19y", "xy", "\xff\xfd\x19", []string{"WILL END-OF-RECORD"}},
+		{"escaped IAC", "caf\xff\xff!", "caf\xff!", "", nil},
+		{"subnegotiation", "#10\xff\xfa\x18\x01\xff\xf0 Town", "#10 Town", "", nil},
+		{"IAC in subnegotiation", "a\xff\xfa\x18\xff\xff\xff\xf0b", "ab", "", nil},
+		{"NOP", "x\xff\xf1y", "xy", "", nil},
+		{"GA", "3 Players logged in.\r\n\xff\xf9rest", "3 Players logged in.\r\n<EOR>rest", "", nil},
+		{"EOR", "You say, \"#10\"Town\"\r\n\xff\xef", "You say, \"#10\"Town\"\r\n<EOR>", "", nil},
+		// A location lookup, with the quotes processLocation splits on
+		// around a negotiation.
+		{"around quotes", "You say, \"\xff\xfb\x03#10\"\xff\xfd\x22Town Square\"\r\n", "You say, \"#10\"Town Square\"\r\n", "\xff\xfe\x03\xff\xfc\x22", []string{"WILL SUPPRESS-GO-AHEAD", "DO LINEMODE"}},
+	} {
+		text, answer, options := telnetRead(t, []byte(tc.input))
+		if text != tc.text {
+			t.Errorf("%s: read %q, want %q", tc.name, text, tc.text)
+		}
+		if string(answer) != tc.answer {
+			t.Errorf("%s: answered %q, want %q", tc.name, answer, tc.answer)
+		}
+		if got := options.List(); !slices.Equal(got, tc.options) {
+			t.Errorf("%s: options %v, want %v", tc.name, got, tc.options)
+		}
+	}
+}
+
+func TestTelnetConnWriteEscapes(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := NewTelnetConn(client, &TelnetOptions{})
+	defer conn.Close()
+	go conn.Write([]byte("say caf\xff\r\n"))
+	got := make([]byte, 11)
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "say caf\xff\xff\r\n" {
+		t.Errorf("wrote %q", got)
+	}
+}
+
+// TestNegotiationInWho interleaves negotiation with the WHO output and the
+// location lookups the state machine parses.
+func TestNegotiationInWho(t *testing.T) {
+	mush := newFakeMUSH(t)
+	who := readFixture(t, "who.txt")
+	who = strings.Replace(who, "Player Name", "Player\xff\xfb\x01 Name", 1)
+	who = strings.Replace(who, "Rhee ", "Rh\xff\xfd\x1fee ", 1)
+	who = strings.Replace(who, "#11", "#1\xff\xfa\x18\x01\xff\xf01", 1)
+	mush.Respond(func(line string) (string, bool) {
+		if strings.EqualFold(line, "WHO") {
+			return mush.bracket(who), true
+		}
+		if match := locationLookup.FindStringSubmatch(line); match != nil {
+			return mush.bracket("You say, \"\xff\xfb\x03" + match[1] + "\"" + mush.rooms[match[2]] + "\xff\xfd\x22\"\r\n"), true
+		}
+		return "", false
+	})
+	ts := startServer(t, mush, serverEnv{}, "--debug")
+	ts.waitForPlayers()
+
+	var debug DebugState
+	if code := ts.get("/debug/state", &debug); code != http.StatusOK {
+		t.Fatalf("/debug/state answered %d", code)
+	}
+	for _, option := range []string{"WILL ECHO", "DO NAWS", "WILL SUPPRESS-GO-AHEAD", "DO LINEMODE"} {
+		if !slices.Contains(debug.TelnetOptions, option) {
+			t.Errorf("/debug/state has %v without %s", debug.TelnetOptions, option)
+		}
+	}
+	waitFor(t, "the refusals", func() bool {
+		replies := mush.Replies()
+		return slices.Contains(replies, "DONT ECHO") && slices.Contains(replies, "WONT NAWS")
+	})
+}
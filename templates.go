@@ -0,0 +1,155 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const statusTemplate = "status.html"
+
+// StatusTemplates renders the status page from user supplied templates.
+type StatusTemplates struct {
+	dir     string
+	debug   bool
+	current atomic.Pointer[template.Template]
+}
+
+type TemplateRoom struct {
+	Location string
+	Players  []*MushPlayer
+}
+
+type TemplateData struct {
+	BasePath    string
+	State       MushState
+	Connection  string
+	Connected   bool
+	LastUpdated time.Time
+	Rooms       []TemplateRoom
+}
+
+func NewStatusTemplates(dir string, debug bool) (*StatusTemplates, error) {
+	t := &StatusTemplates{dir: dir, debug: debug}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *StatusTemplates) parse() (*template.Template, error) {
+	return template.ParseGlob(filepath.Join(t.dir, "*.html"))
+}
+
+func (t *StatusTemplates) reload() error {
+	parsed, err := t.parse()
+	if err != nil {
+		return err
+	}
+	if parsed.Lookup(statusTemplate) == nil {
+		return &os.PathError{Op: "lookup", Path: filepath.Join(t.dir, statusTemplate), Err: os.ErrNotExist}
+	}
+	t.current.Store(parsed)
+	return nil
+}
+
+// reloadOnHangup re-parses the templates whenever the process gets SIGHUP.
+// A broken template is logged and the previous one is kept.
+func (t *StatusTemplates) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := t.reload(); err != nil {
+			log.Println("Could not reload templates:", err)
+			continue
+		}
+		log.Println("Reloaded templates.")
+	}
+}
+
+func (t *StatusTemplates) get() (*template.Template, error) {
+	if t.debug {
+		return t.parse()
+	}
+	return t.current.Load(), nil
+}
+
+// templateData collects everything the status template gets to see. The
+// caller must hold s.mu.
+func (s *ServerState) templateData() TemplateData {
+	rooms := make(map[string]*TemplateRoom)
+	for _, player := range s.mushState.Players {
+		if player == nil {
+			continue
+		}
+		name := player.LocationName()
+		room, ok := rooms[name]
+		if !ok {
+			room = &TemplateRoom{Location: name}
+			rooms[name] = room
+		}
+		room.Players = append(room.Players, player)
+	}
+	data := TemplateData{
+		BasePath:    s.config.BasePath,
+		State:       *s.mushState,
+		Connection:  s.currentState,
+		Connected:   isLoggedIn(s.currentState),
+		LastUpdated: s.lastSuccessfulWho,
+		Rooms:       make([]TemplateRoom, 0, len(rooms)),
+	}
+	for _, room := range rooms {
+		data.Rooms = append(data.Rooms, *room)
+	}
+	sort.Slice(data.Rooms, func(i, j int) bool {
+		return data.Rooms[i].Location < data.Rooms[j].Location
+	})
+	return data
+}
+
+func (s *ServerState) serveTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := s.templates.get()
+	if err != nil {
+		s.logRequest(r, slog.LevelError, "Could not parse templates: %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	var buffer bytes.Buffer
+	s.mu.RLock()
+	err = tmpl.ExecuteTemplate(&buffer, statusTemplate, s.templateData())
+	s.mu.RUnlock()
+	if err != nil {
+		s.logRequest(r, slog.LevelError, "Could not render status template: %v", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buffer.Bytes())
+}
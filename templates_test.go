@@ -0,0 +1,63 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplateRequiresToken(t *testing.T) {
+	s := newTestState(t, "--template-dir", "testdata/templates", "--api-token", "secret")
+	templates, err := NewStatusTemplates(s.config.TemplateDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.templates = templates
+	setPlayers(s, &MushPlayer{Name: "Alice", Location: "#12"})
+	handler := s.handler(s.routes())
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: got %d, want 401", recorder.Code)
+	}
+	if strings.Contains(recorder.Body.String(), "Alice") {
+		t.Errorf("without a token the page shows the players: %s", recorder.Body)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "<li>Alice in #12</li>") {
+		t.Errorf("with the token: got %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestHomeWithoutTokens(t *testing.T) {
+	s := newTestState(t)
+	recorder := httptest.NewRecorder()
+	s.handler(s.routes()).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+}
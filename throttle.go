@@ -0,0 +1,75 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// parseCommandRate reads --command-rate, e.g. 1/2s for one command every two
+// seconds or 3/s, into the minimum gap between two commands. An empty rate
+// means no throttle.
+func parseCommandRate(rate string) (time.Duration, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	count, period, ok := strings.Cut(rate, "/")
+	if !ok {
+		return 0, errors.New("expected commands/duration, e.g. 1/2s")
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return 0, errors.New("the number of commands must be a positive integer")
+	}
+	if period != "" && !unicode.IsDigit(rune(period[0])) {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return 0, errors.New("the duration must be positive, e.g. 2s")
+	}
+	return d / time.Duration(n), nil
+}
+
+// throttleDelay returns how long the next command has to wait to keep the
+// gap to the last one sent. The caller holds s.mu.
+func (s *ServerState) throttleDelay() time.Duration {
+	if s.commandGap <= 0 || s.lastSent.IsZero() {
+		return 0
+	}
+	return max(s.commandGap-s.now().Sub(s.lastSent), 0)
+}
+
+// holdDispatch arranges for loopWorker to dispatch again once the throttle
+// lets the next command through. The caller holds s.mu.
+func (s *ServerState) holdDispatch(delay time.Duration) {
+	if s.throttleTimer != nil {
+		return
+	}
+	s.metrics.RecordThrottled()
+	s.throttleTimer = time.AfterFunc(delay, func() {
+		select {
+		case s.throttled <- struct{}{}:
+		default:
+		}
+	})
+}
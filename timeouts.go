@@ -0,0 +1,74 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newHTTPServer sets up the HTTP server with the timeouts and limits of the
+// config. Requests get baseCtx as their context.
+func newHTTPServer(config ServerConfig, handler http.Handler, baseCtx context.Context) *http.Server {
+	return &http.Server{
+		Addr:              config.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: 3 * time.Second,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderSize,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+}
+
+// The deadlines set from --read-timeout and --write-timeout cover a whole
+// request, which would cut off the handlers that legitimately stay open.
+// Those move the deadlines of their own connection instead.
+
+// extendWriteDeadline gives a handler which waits for up to d before it
+// writes its response, like a long poll, that much longer than
+// --write-timeout.
+func (s *ServerState) extendWriteDeadline(w http.ResponseWriter, d time.Duration) {
+	if s.config.WriteTimeout <= 0 {
+		return
+	}
+	controller := http.NewResponseController(w)
+	setDeadline(controller.SetWriteDeadline(time.Now().Add(d + s.config.WriteTimeout)))
+}
+
+// clearDeadlines lifts the read and write deadlines off a streaming
+// response, which sets a deadline for each write itself. The read deadline
+// matters too: once it passes, the server cancels the request context.
+func clearDeadlines(w http.ResponseWriter) {
+	controller := http.NewResponseController(w)
+	setDeadline(controller.SetReadDeadline(time.Time{}))
+	setDeadline(controller.SetWriteDeadline(time.Time{}))
+}
+
+func setDeadline(err error) {
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println("Could not set connection deadline:", err)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPServerTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		args              []string
+		read, write, idle time.Duration
+		maxHeaderBytes    int
+	}{
+		{nil, 30 * time.Second, 30 * time.Second, 2 * time.Minute, 65536},
+		{[]string{"--read-timeout", "5s", "--write-timeout", "0", "--idle-timeout", "10s", "--max-header-bytes", "4096"},
+			5 * time.Second, 0, 10 * time.Second, 4096},
+	} {
+		config := testConfig(t, tc.args...)
+		server := newHTTPServer(config, http.NotFoundHandler(), context.Background())
+		if server.ReadTimeout != tc.read || server.WriteTimeout != tc.write || server.IdleTimeout != tc.idle || server.MaxHeaderBytes != tc.maxHeaderBytes {
+			t.Errorf("%v: read %v, write %v, idle %v, max header bytes %d", tc.args,
+				server.ReadTimeout, server.WriteTimeout, server.IdleTimeout, server.MaxHeaderBytes)
+		}
+		if server.ReadHeaderTimeout <= 0 {
+			t.Errorf("%v: no ReadHeaderTimeout", tc.args)
+		}
+	}
+}
+
+// startTimeoutServer serves s through newHTTPServer, with the timeouts of
+// its config.
+func startTimeoutServer(t *testing.T, s *ServerState) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newHTTPServer(*s.config, s.handler(s.routes()), context.Background())
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTimeoutsSpareStreams(t *testing.T) {
+	s := newTestState(t, "--read-timeout", "100ms", "--write-timeout", "100ms")
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker"})
+	server := startTimeoutServer(t, s)
+
+	response, err := http.Get(server.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	stream := bufio.NewReader(response.Body)
+	if line, err := stream.ReadString('\n'); err != nil || line != "event: snapshot\n" {
+		t.Fatalf("first line %q, %v", line, err)
+	}
+	for {
+		if line, err := stream.ReadString('\n'); err != nil || line == "\n" {
+			break
+		}
+	}
+	time.Sleep(300 * time.Millisecond)
+	setPlayers(s, &MushPlayer{Name: "Walker"}, &MushPlayer{Name: "Rhee"})
+	if line, err := stream.ReadString('\n'); err != nil || line != "event: update\n" {
+		t.Errorf("after the timeouts: %q, %v", line, err)
+	}
+}
+
+func TestWriteTimeoutExtendedForLongPolls(t *testing.T) {
+	s := newTestState(t, "--write-timeout", "100ms")
+	setLoggedIn(s)
+	setPlayers(s, &MushPlayer{Name: "Walker"})
+	server := startTimeoutServer(t, s)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		setPlayers(s, &MushPlayer{Name: "Walker"}, &MushPlayer{Name: "Rhee"})
+	}()
+
+	s.mu.RLock()
+	rev := s.revision
+	s.mu.RUnlock()
+	response, err := http.Get(fmt.Sprintf("%s/api?wait=5s&rev=%d", server.URL, rev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("long poll: %d, %v", response.StatusCode, err)
+	}
+	if want := `"Rhee"`; !bytes.Contains(body, []byte(want)) {
+		t.Errorf("long poll answered %s", body)
+	}
+}
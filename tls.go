@@ -0,0 +1,158 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// CertReloader serves a TLS certificate which is reloaded from disk on
+// SIGHUP, so renewed certificates are picked up without a restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	current  atomic.Pointer[tls.Certificate]
+}
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.current.Store(&cert)
+	return nil
+}
+
+// reloadOnHangup reloads the certificate whenever the process gets SIGHUP.
+// A broken certificate is logged and the previous one is kept.
+func (c *CertReloader) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := c.reload(); err != nil {
+			log.Println("Could not reload TLS certificate:", err)
+			continue
+		}
+		log.Println("Reloaded TLS certificate.")
+	}
+}
+
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
+
+// telnetTLSConfig builds the TLS configuration for the MUSH connection, or
+// returns nil without --telnet-tls.
+func telnetTLSConfig(config *ServerConfig) (*tls.Config, error) {
+	if !config.TelnetTLS {
+		if config.TLSSkipVerify || config.TelnetCAFile != "" || config.TelnetCert != "" || config.TelnetKey != "" || config.TelnetSNI != "" {
+			return nil, errors.New("--telnet-insecure-skip-verify, --telnet-ca-file, --telnet-client-cert, --telnet-client-key and --telnet-server-name require --telnet-tls")
+		}
+		return nil, nil
+	}
+	if (config.TelnetCert == "") != (config.TelnetKey == "") {
+		return nil, errors.New("--telnet-client-cert and --telnet-client-key must be given together")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.TLSSkipVerify, ServerName: config.TelnetSNI}
+	if config.TelnetCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.TelnetCert, config.TelnetKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %w", err)
+		}
+		// An expired certificate is still presented, maybe the other end
+		// doesn't mind, but it's the likely cause of a rejection.
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Now().After(leaf.NotAfter) {
+			log.Printf("The client certificate in %s expired on %s.", config.TelnetCert, leaf.NotAfter.Format(time.DateOnly))
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.TelnetCAFile != "" {
+		pem, err := os.ReadFile(config.TelnetCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TelnetCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// HandshakeError is a failure of the TLS handshake with the MUSH, after the
+// connection itself was established.
+type HandshakeError struct {
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	if isCertificateError(e.Err) {
+		return "TLS certificate verification failed: " + e.Err.Error()
+	}
+	return "TLS handshake failed: " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// isTLSAlert reports whether a read failed because the other end sent a TLS
+// alert. With TLS 1.3 the server checks our client certificate after the
+// handshake returned, so a rejection only shows on the first read.
+func isTLSAlert(err string) bool {
+	return strings.Contains(err, "remote error: tls: ")
+}
+
+// tlsRejected handles a session the MUSH's TLS endpoint ended with an
+// alert, which is reported like a failed handshake rather than a lost
+// connection. The caller holds s.mu.
+func (s *ServerState) tlsRejected(err string) {
+	s.logger.Printf("The TLS endpoint of %s rejected the connection: %s", s.config.TelnetHost, err)
+	s.recordError("TLS handshake failed: " + err)
+	s.dialError = s.lastError
+	s.metrics.RecordHandshakeFailure()
+	s.setState(STATE_NOT_CONNECTED)
+	s.scheduleReconnect()
+	s.sessionStart = time.Time{}
+}
+
+// isCertificateError reports whether a dial failed because the MUSH's
+// certificate couldn't be verified, rather than the connection itself.
+func isCertificateError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	return errors.As(err, &verifyErr)
+}
@@ -0,0 +1,152 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// traceQueueSize is how many records may wait for the trace file. Beyond
+// that they are dropped rather than holding up the state machine.
+const traceQueueSize = 1024
+
+// TelnetTrace appends every message sent to and received from the MUSH to
+// --trace-telnet. The file is written by a goroutine of its own, so a slow
+// disk never blocks the telnet session, and once it grows past maxSize it
+// is moved to <path>.1 and started over.
+type TelnetTrace struct {
+	path    string
+	maxSize int64
+	records chan string
+	dropped atomic.Uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewTelnetTrace(path string, maxSize int64) (*TelnetTrace, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	t := &TelnetTrace{
+		path:    path,
+		maxSize: maxSize,
+		records: make(chan string, traceQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run(file, info.Size())
+	return t, nil
+}
+
+// Add queues a message for the trace, or drops it if the queue is full. The
+// text is quoted, so line endings, escape sequences and invalid UTF-8 show
+// as they were. A nil trace does nothing.
+func (t *TelnetTrace) Add(game string, direction string, text string) {
+	if t == nil {
+		return
+	}
+	record := time.Now().UTC().Format(time.RFC3339Nano) + " " + direction
+	if game != "" {
+		record += " [" + game + "]"
+	}
+	record += " " + strconv.Quote(text) + "\n"
+	select {
+	case t.records <- record:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Close writes what is queued and closes the file. Records added later are
+// never written.
+func (t *TelnetTrace) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+func (t *TelnetTrace) run(file *os.File, size int64) {
+	defer close(t.done)
+	defer func() {
+		// file changes with every rotation.
+		file.Close()
+	}()
+	write := func(record string) {
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			record = fmt.Sprintf("%s dropped %d records\n", time.Now().UTC().Format(time.RFC3339Nano), dropped) + record
+		}
+		if size > 0 && size+int64(len(record)) > t.maxSize {
+			// If it can't be moved, it is started over in place so it
+			// never grows past the limit.
+			if rotated, err := t.rotate(file); err != nil {
+				log.Println("Could not rotate the telnet trace, truncating it:", err)
+				file.Truncate(0)
+			} else {
+				file = rotated
+			}
+			size = 0
+		}
+		n, err := file.WriteString(record)
+		size += int64(n)
+		if err != nil {
+			log.Println("Could not write the telnet trace:", err)
+		}
+	}
+	for {
+		select {
+		case record := <-t.records:
+			write(record)
+		case <-t.stop:
+			for {
+				select {
+				case record := <-t.records:
+					write(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// rotate moves the full trace to <path>.1, replacing the previous one, and
+// opens a new file in its place.
+func (t *TelnetTrace) rotate(file *os.File) (*os.File, error) {
+	if err := os.Rename(t.path, t.path+".1"); err != nil {
+		return nil, err
+	}
+	rotated, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return rotated, nil
+}
@@ -0,0 +1,74 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func (s *ServerState) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	// The connection sets its own write deadlines after the upgrade.
+	clearDeadlines(w)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logRequest(r, slog.LevelWarn, "websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	updates := s.broadcaster.Subscribe()
+	defer s.broadcaster.Unsubscribe(updates)
+
+	// We never expect anything from the client, but reading is required to
+	// process control frames and to notice when the connection goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case snapshot := <-updates:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, snapshot); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+			return
+		}
+	}
+}
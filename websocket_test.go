@@ -0,0 +1,88 @@
+/*
+midgaard_matrix_bot, a Matrix bot which sets a bridge to MUD
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWebSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readSnapshot(t *testing.T, conn *websocket.Conn) StatusV1 {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status StatusV1
+	if err := json.Unmarshal(message, &status); err != nil {
+		t.Fatalf("snapshot %q: %v", message, err)
+	}
+	return status
+}
+
+func TestWebSocketFanOut(t *testing.T) {
+	s := newTestState(t)
+	server := httptest.NewServer(s.withAuth(s.serveWebSocket))
+	defer server.Close()
+
+	clients := []*websocket.Conn{dialWebSocket(t, server.URL), dialWebSocket(t, server.URL)}
+	// Every client starts with the snapshot from before it subscribed.
+	for i, conn := range clients {
+		if status := readSnapshot(t, conn); len(status.Players) != 0 {
+			t.Errorf("client %d: initial snapshot has players %v", i, status.Players)
+		}
+	}
+
+	setPlayers(s, &MushPlayer{Name: "Alice", Location: "#12"}, &MushPlayer{Name: "Bob", Location: "#12"})
+	for i, conn := range clients {
+		status := readSnapshot(t, conn)
+		if len(status.Players) != 2 || status.Players[0].Name != "Alice" || status.Players[1].Name != "Bob" {
+			t.Errorf("client %d: got players %+v, want Alice and Bob", i, status.Players)
+		}
+	}
+}
+
+func TestWebSocketRequiresToken(t *testing.T) {
+	s := newTestState(t, "--api-token", "secret")
+	server := httptest.NewServer(s.withAuth(s.serveWebSocket))
+	defer server.Close()
+
+	_, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err == nil {
+		t.Fatal("dialed without a token")
+	}
+	if response == nil || response.StatusCode != 401 {
+		t.Fatalf("got response %v, want 401", response)
+	}
+}